@@ -0,0 +1,104 @@
+package icache
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+const (
+	// AccessHit hit cache
+	AccessHit = "hit"
+	// AccessMiss miss cache, loaded from source or shared flight
+	AccessMiss = "miss"
+	// AccessError op fail
+	AccessError = "error"
+	// AccessOK op other than Get done
+	AccessOK = "ok"
+)
+
+// AccessRecord access log record of Get or delete ops
+type AccessRecord struct {
+	Time          time.Time
+	Op            Op
+	Key           string
+	Result        string        // AccessHit or AccessMiss of Get, AccessOK of other ops, or AccessError
+	Latency       time.Duration // op latency
+	SourceLatency time.Duration // 0 if not loaded from source
+	Shared        bool          // result shared from other goroutine in flight group
+	Err           error
+}
+
+// AccessLogger access logger
+type AccessLogger interface {
+	LogAccess(rec AccessRecord)
+}
+
+// AccessLoggerFunc func
+type AccessLoggerFunc func(rec AccessRecord)
+
+// LogAccess log access
+func (f AccessLoggerFunc) LogAccess(rec AccessRecord) {
+	f(rec)
+}
+
+// AccessLogOpt access log sampling option, a record is logged only if it passes all samplers
+type AccessLogOpt struct {
+	Rate      float64                  // probability of logging a record, <= 0 or >= 1 means log all
+	KeyRate   float64                  // fraction of keys logged, chosen by key hash, <= 0 or >= 1 means all keys
+	KeyFilter func(strKey string) bool // only log keys return true, nil means all keys
+}
+
+// sampled check sampling
+func (opt *AccessLogOpt) sampled(strKey string) bool {
+	if opt.KeyFilter != nil && !opt.KeyFilter(strKey) {
+		return false
+	}
+	if opt.KeyRate > 0 && opt.KeyRate < 1 {
+		h := fnv.New32a()
+		h.Write([]byte(strKey))
+		if float64(h.Sum32()%10000) >= opt.KeyRate*10000 {
+			return false
+		}
+	}
+	if opt.Rate > 0 && opt.Rate < 1 && rand.Float64() >= opt.Rate {
+		return false
+	}
+	return true
+}
+
+// accessLogInterceptor interceptor log Get, Delete, DeletePrefix and BumpNamespace
+func accessLogInterceptor(logger AccessLogger, opt AccessLogOpt) Interceptor {
+	return func(ctx context.Context, info *OpInfo, next Handler) error {
+		if info.Op == OpLoad || info.Op == OpSource {
+			return next(ctx, info)
+		}
+		if !opt.sampled(info.Key) {
+			return next(ctx, info)
+		}
+		startTime := time.Now()
+		err := next(ctx, info)
+		rec := AccessRecord{
+			Time:          startTime,
+			Op:            info.Op,
+			Key:           info.Key,
+			Latency:       time.Since(startTime),
+			SourceLatency: info.SourceLatency,
+			Shared:        info.Shared,
+			Err:           err,
+		}
+		switch {
+		case err != nil:
+			rec.Result = AccessError
+		case info.Op != OpGet:
+			rec.Result = AccessOK
+		case info.Hit:
+			rec.Result = AccessHit
+		default:
+			rec.Result = AccessMiss
+		}
+		logger.LogAccess(rec)
+		return err
+	}
+}
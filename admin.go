@@ -0,0 +1,227 @@
+package icache
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultAdminHotKeys = 20
+)
+
+// AdminAuthFunc admin auth hook, return false to reject request
+type AdminAuthFunc func(r *http.Request) bool
+
+// AdminHandler http handler to inspect and manage registered ICache, JSON responses
+//
+//	GET  /caches                             list caches and stats
+//	GET  /key?cache=name&key=k               look up key and remaining ttl
+//	POST /delete?cache=name&key=k            delete key
+//	POST /delete_prefix?cache=name&prefix=p  delete keys with prefix
+//	POST /purge?cache=name                   remove all keys
+//	GET  /hotkeys?cache=name&n=20            hot keys and hot source keys
+type AdminHandler struct {
+	reg  *Registry
+	auth AdminAuthFunc
+	mux  *http.ServeMux
+}
+
+// NewAdminHandler new admin handler, auth can be nil, mount with http.StripPrefix
+func NewAdminHandler(reg *Registry, auth AdminAuthFunc) *AdminHandler {
+	h := &AdminHandler{
+		reg:  reg,
+		auth: auth,
+		mux:  http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/caches", h.handleCaches)
+	h.mux.HandleFunc("/key", h.handleKey)
+	h.mux.HandleFunc("/delete", h.handleDelete)
+	h.mux.HandleFunc("/delete_prefix", h.handleDeletePrefix)
+	h.mux.HandleFunc("/purge", h.handlePurge)
+	h.mux.HandleFunc("/hotkeys", h.handleHotKeys)
+	return h
+}
+
+// ServeHTTP http handler
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.auth != nil && !h.auth(r) {
+		adminError(w, http.StatusForbidden, fmt.Errorf("forbidden"))
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// AdminLatency latency summary in admin response
+type AdminLatency struct {
+	Count  int64   `json:"count"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+}
+
+// AdminCache cache info in admin response
+type AdminCache struct {
+	Name    string                  `json:"name"`
+	Stats   StatsSnapshot           `json:"stats"`
+	Latency map[string]AdminLatency `json:"latency"`
+}
+
+func (h *AdminHandler) handleCaches(w http.ResponseWriter, r *http.Request) {
+	caches := make([]AdminCache, 0)
+	h.reg.Range(func(strName string, ic *ICache) bool {
+		latency := make(map[string]AdminLatency, len(promLatencies))
+		for _, l := range promLatencies {
+			hist := l.get(&ic.stats)
+			latency[l.op] = AdminLatency{
+				Count:  hist.Count(),
+				MeanMs: durationMs(hist.Mean()),
+				P50Ms:  durationMs(hist.Percentile(50)),
+				P99Ms:  durationMs(hist.Percentile(99)),
+			}
+		}
+		caches = append(caches, AdminCache{Name: strName, Stats: ic.Snapshot(), Latency: latency})
+		return true
+	})
+	adminJSON(w, caches)
+}
+
+// AdminKey key info in admin response
+type AdminKey struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+	TTLMs int64       `json:"ttl_ms"` // 0 never expire, < 0 unknown
+}
+
+func (h *AdminHandler) handleKey(w http.ResponseWriter, r *http.Request) {
+	ic, ok := h.getCache(w, r)
+	if !ok {
+		return
+	}
+	strKey := r.FormValue("key")
+	valIf, ttl, err := ic.Peek(r.Context(), strKey)
+	if err != nil {
+		if ic.cache.IsErrNotFound(err) {
+			adminError(w, http.StatusNotFound, err)
+		} else {
+			adminError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	ttlMs := int64(ttl / time.Millisecond)
+	if ttl < 0 {
+		ttlMs = -1
+	}
+	adminJSON(w, AdminKey{Key: strKey, Value: adminValue(valIf), TTLMs: ttlMs})
+}
+
+func (h *AdminHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	ic, ok := h.getCacheForWrite(w, r)
+	if !ok {
+		return
+	}
+	if err := ic.Delete(r.Context(), r.FormValue("key")); err != nil {
+		adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	adminJSON(w, map[string]int{"deleted": 1})
+}
+
+func (h *AdminHandler) handleDeletePrefix(w http.ResponseWriter, r *http.Request) {
+	ic, ok := h.getCacheForWrite(w, r)
+	if !ok {
+		return
+	}
+	strPrefix := r.FormValue("prefix")
+	if strPrefix == "" {
+		adminError(w, http.StatusBadRequest, fmt.Errorf("empty prefix, use purge"))
+		return
+	}
+	cnt, err := ic.DeletePrefix(r.Context(), strPrefix)
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	adminJSON(w, map[string]int{"deleted": cnt})
+}
+
+func (h *AdminHandler) handlePurge(w http.ResponseWriter, r *http.Request) {
+	ic, ok := h.getCacheForWrite(w, r)
+	if !ok {
+		return
+	}
+	if err := ic.Purge(r.Context()); err != nil {
+		adminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	adminJSON(w, map[string]bool{"purged": true})
+}
+
+// AdminHotKeys hot keys in admin response
+type AdminHotKeys struct {
+	HotKeys       []HotKey `json:"hot_keys"`
+	HotSourceKeys []HotKey `json:"hot_source_keys"`
+}
+
+func (h *AdminHandler) handleHotKeys(w http.ResponseWriter, r *http.Request) {
+	ic, ok := h.getCache(w, r)
+	if !ok {
+		return
+	}
+	n := defaultAdminHotKeys
+	if strN := r.FormValue("n"); strN != "" {
+		var err error
+		if n, err = strconv.Atoi(strN); err != nil {
+			adminError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	adminJSON(w, AdminHotKeys{HotKeys: ic.HotKeys(n), HotSourceKeys: ic.HotSourceKeys(n)})
+}
+
+func (h *AdminHandler) getCache(w http.ResponseWriter, r *http.Request) (*ICache, bool) {
+	strName := r.FormValue("cache")
+	ic, ok := h.reg.Get(strName)
+	if !ok {
+		adminError(w, http.StatusNotFound, fmt.Errorf("cache %q not found", strName))
+		return nil, false
+	}
+	return ic, true
+}
+
+func (h *AdminHandler) getCacheForWrite(w http.ResponseWriter, r *http.Request) (*ICache, bool) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		adminError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return nil, false
+	}
+	return h.getCache(w, r)
+}
+
+// adminValue value for json, utf8 bytes as string
+func adminValue(valIf interface{}) interface{} {
+	if b, ok := valIf.([]byte); ok && utf8.Valid(b) {
+		return string(b)
+	}
+	if _, err := json.Marshal(valIf); err != nil {
+		return fmt.Sprintf("%+v", valIf)
+	}
+	return valIf
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func adminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func adminError(w http.ResponseWriter, iCode int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(iCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
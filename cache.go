@@ -2,6 +2,7 @@ package icache
 
 import (
 	"context"
+	"time"
 )
 
 // CacheIf cache interface
@@ -11,3 +12,96 @@ type CacheIf interface {
 	Del(context.Context, string) error
 	IsErrNotFound(err error) bool
 }
+
+// CacheIterIf optional cache interface, support key iteration and prefix deletion
+type CacheIterIf interface {
+	Range(context.Context, func(string) bool) error
+	DelPrefix(context.Context, string) (int, error)
+}
+
+// ExpireOpt expire option
+type ExpireOpt struct {
+	TTL  time.Duration // absolute ttl, 0 means never expire
+	Idle time.Duration // idle timeout renewed on each get, 0 means no idle timeout
+}
+
+// CacheExpireIf optional cache interface, support time.Duration ttl and idle timeout
+type CacheExpireIf interface {
+	SetWithExpire(context.Context, string, interface{}, ExpireOpt) error
+}
+
+// CachePeekIf optional cache interface, get value and remaining ttl
+// without renewing idle timeout or lru position
+type CachePeekIf interface {
+	Peek(context.Context, string) (interface{}, time.Duration, error)
+}
+
+// CachePinIf optional cache interface, pinned keys are never evicted by capacity
+// and never expire, only Del removes them. ICache pins namespace generations
+type CachePinIf interface {
+	// SetPinned set pinned value, replace existing value of key
+	SetPinned(context.Context, string, interface{}) error
+	// AddPinned set pinned value if key is absent, atomic, return value kept by cache
+	AddPinned(context.Context, string, interface{}) (interface{}, error)
+}
+
+// CacheUnwrapIf optional cache interface of wrappers such as CompressCache,
+// ICache checks optional interfaces of the whole chain through it
+type CacheUnwrapIf interface {
+	Unwrap() CacheIf
+}
+
+// cacheSupports check every cache of wrapper chain, wrappers forward optional
+// interfaces only when the wrapped cache implements them
+func cacheSupports(cache CacheIf, check func(CacheIf) bool) bool {
+	for {
+		if !check(cache) {
+			return false
+		}
+		wrapper, ok := cache.(CacheUnwrapIf)
+		if !ok {
+			return true
+		}
+		cache = wrapper.Unwrap()
+	}
+}
+
+func isExpireCache(cache CacheIf) bool {
+	_, ok := cache.(CacheExpireIf)
+	return ok
+}
+
+func isEvictCache(cache CacheIf) bool {
+	_, ok := cache.(CacheEvictIf)
+	return ok
+}
+
+func isEvictCountCache(cache CacheIf) bool {
+	_, ok := cache.(CacheEvictCountIf)
+	return ok
+}
+
+func isStatCache(cache CacheIf) bool {
+	_, ok := cache.(CacheStatIf)
+	return ok
+}
+
+func isPinCache(cache CacheIf) bool {
+	_, ok := cache.(CachePinIf)
+	return ok
+}
+
+func isPeekCache(cache CacheIf) bool {
+	_, ok := cache.(CachePeekIf)
+	return ok
+}
+
+func isIterCache(cache CacheIf) bool {
+	_, ok := cache.(CacheIterIf)
+	return ok
+}
+
+func isDumpCache(cache CacheIf) bool {
+	_, ok := cache.(CacheDumpIf)
+	return ok
+}
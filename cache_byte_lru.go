@@ -4,8 +4,6 @@ import (
 	"context"
 	"fmt"
 	"time"
-
-	lru "github.com/hashicorp/golang-lru"
 )
 
 /*
@@ -17,70 +15,51 @@ import (
 
 // LRUByteCache
 type LRUByteCache struct {
-	lru *lru.Cache
+	*lruBase
 }
 
 // NewLRUByteCache new lru cache
 func NewLRUByteCache(iSize int) CacheIf {
-	return NewLRUByteCacheWithEvict(iSize, nil)
+	return NewLRUByteCacheWithOpts(iSize)
 }
 
-// NewLRUByteCacheWithEvict new lru cache
+// NewLRUByteCacheWithEvict new lru cache, onEvicted receives the stored item on eviction and removal
+//
+// Deprecated: use NewLRUByteCacheWithOpts and SetLRUEvictFunc, which report evict reason
 func NewLRUByteCacheWithEvict(iSize int, onEvicted func(key interface{}, value interface{})) CacheIf {
-	cache, err := lru.NewWithEvict(iSize, onEvicted)
-	if err != nil {
-		panic(err)
-	}
-	return &LRUByteCache{lru: cache}
+	return NewLRUByteCacheWithOpts(iSize, setLRURawEvict(onEvicted))
 }
 
-type lruByteItem struct {
-	val      []byte
-	expireTs int64
+// NewLRUByteCacheWithOpts new lru cache with options
+func NewLRUByteCacheWithOpts(iSize int, opts ...LRUOption) CacheIf {
+	c := &LRUByteCache{lruBase: newLRUBase(iSize, opts...)}
+	c.sizeOf = func(strKey string, valIf interface{}) int64 {
+		return int64(len(strKey) + len(valIf.([]byte)))
+	}
+	return c
 }
 
 // Get get
 func (c *LRUByteCache) Get(ctx context.Context, strKey string) (interface{}, error) {
-	valIf, ok := c.lru.Get(strKey)
-	if !ok {
-		return nil, ErrNotFound
-	}
-	item := valIf.(*lruByteItem)
-	// check ttl
-	if item.expireTs > 0 && time.Now().Unix() > item.expireTs {
-		c.lru.Remove(strKey)
-		return nil, ErrNotFound
-	}
-	return item.val, nil
+	return c.get(strKey)
 }
 
 // Set set
 func (c *LRUByteCache) Set(ctx context.Context, strKey string, valIf interface{}, iTTL int32) error {
-	item := &lruByteItem{
-		// val: valIf,
-	}
+	return c.SetWithExpire(ctx, strKey, valIf, ExpireOpt{TTL: time.Duration(iTTL) * time.Second})
+}
+
+// SetWithExpire set with expire option
+func (c *LRUByteCache) SetWithExpire(ctx context.Context, strKey string, valIf interface{}, opt ExpireOpt) error {
+	var val []byte
 	switch valIf.(type) {
 	case []byte:
-		item.val = valIf.([]byte)
+		val = valIf.([]byte)
 	case string:
-		item.val = []byte(valIf.(string))
+		val = []byte(valIf.(string))
 	default:
 		return fmt.Errorf("LRUByteCache only support []byte and string type")
 	}
-	if iTTL > 0 {
-		item.expireTs = time.Now().Unix() + int64(iTTL)
-	}
-	c.lru.Add(strKey, item)
+	c.set(strKey, val, opt)
 	return nil
 }
-
-// Del del
-func (c *LRUByteCache) Del(ctx context.Context, strKey string) error {
-	c.lru.Remove(strKey)
-	return nil
-}
-
-// IsErrNotFound is not found err
-func (c *LRUByteCache) IsErrNotFound(err error) bool {
-	return err == ErrNotFound
-}
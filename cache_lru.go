@@ -0,0 +1,469 @@
+package icache
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+const (
+	defaultJanitorMaxScan = 1000
+)
+
+// LRUOption lru cache option
+type LRUOption struct {
+	f func(o *lruOptions)
+}
+
+type lruOptions struct {
+	evictFuncs      []EvictFunc
+	rawEvict        func(key interface{}, value interface{})
+	janitorInterval time.Duration
+	janitorMaxScan  int
+	clock           Clock
+}
+
+// SetLRUEvictFunc add evict callback
+func SetLRUEvictFunc(fn EvictFunc) LRUOption {
+	return LRUOption{func(o *lruOptions) {
+		o.evictFuncs = append(o.evictFuncs, fn)
+	}}
+}
+
+// SetLRUJanitor start background goroutine, scan at most iMaxScan keys every interval
+// and remove expired entries, stop by Close
+func SetLRUJanitor(interval time.Duration, iMaxScan int) LRUOption {
+	return LRUOption{func(o *lruOptions) {
+		o.janitorInterval = interval
+		o.janitorMaxScan = iMaxScan
+	}}
+}
+
+// SetLRUClock set clock of ttl, idle timeout and janitor, default time package
+func SetLRUClock(clock Clock) LRUOption {
+	return LRUOption{func(o *lruOptions) {
+		o.clock = clock
+	}}
+}
+
+// setLRURawEvict compatible with NewLRUXXXCacheWithEvict
+func setLRURawEvict(fn func(key interface{}, value interface{})) LRUOption {
+	return LRUOption{func(o *lruOptions) {
+		o.rawEvict = fn
+	}}
+}
+
+// lruItem lru item
+type lruItem struct {
+	val interface{}
+	lruExpire
+	reason   EvictReason   // set before remove, mu held
+	scanElem *list.Element // element in scan list, nil if never expire or no janitor
+}
+
+// lruEvicted item removed under mu, callbacks fire after unlock
+type lruEvicted struct {
+	key  interface{}
+	item *lruItem
+}
+
+// lruBase common part of lru caches
+type lruBase struct {
+	mu       sync.Mutex // guard lru, pinned and pending, every lookup and mutation hold it
+	lru      *simplelru.LRU
+	pinned   map[string]interface{} // pinned keys, out of lru, no evict callback
+	pending  []lruEvicted
+	opts     lruOptions
+	capacity int
+	sizeOf   func(strKey string, valIf interface{}) int64 // nil means bytes unknown
+
+	bytes       int64 // atomic
+	evictions   int64 // atomic
+	expirations int64 // atomic
+
+	evictMu    sync.RWMutex
+	evictFuncs []EvictFunc
+
+	janitor  *janitor
+	scan     *list.List    // keys which may expire, janitor only, mu held
+	scanNext *list.Element // janitor scan cursor in scan, nil means from front
+}
+
+func newLRUBase(iSize int, opts ...LRUOption) *lruBase {
+	c := &lruBase{capacity: iSize}
+	for _, opt := range opts {
+		opt.f(&c.opts)
+	}
+	if c.opts.clock == nil {
+		c.opts.clock = realClock{}
+	}
+	c.evictFuncs = c.opts.evictFuncs
+	cache, err := simplelru.NewLRU(iSize, c.onEvicted)
+	if err != nil {
+		panic(err)
+	}
+	c.lru = cache
+	if c.opts.janitorInterval > 0 {
+		if c.opts.janitorMaxScan <= 0 {
+			c.opts.janitorMaxScan = defaultJanitorMaxScan
+		}
+		c.scan = list.New()
+		c.janitor = startJanitor(c.opts.clock, c.opts.janitorInterval, func() {
+			c.removeExpired(c.opts.janitorMaxScan)
+		})
+	}
+	return c
+}
+
+// onEvicted simplelru evict callback, mu held, counters and bytes are updated here
+// so every removal is accounted exactly once
+func (c *lruBase) onEvicted(key interface{}, value interface{}) {
+	item := value.(*lruItem)
+	strKey, _ := key.(string)
+	switch item.reason {
+	case EvictCapacity:
+		atomic.AddInt64(&c.evictions, 1)
+	case EvictExpired:
+		atomic.AddInt64(&c.expirations, 1)
+	}
+	c.addBytes(strKey, item.val, -1)
+	if item.scanElem != nil {
+		if c.scanNext == item.scanElem {
+			c.scanNext = item.scanElem.Next()
+		}
+		c.scan.Remove(item.scanElem)
+	}
+	c.pending = append(c.pending, lruEvicted{key: key, item: item})
+}
+
+// unlock release mu, then fire callbacks of items removed while it was held
+func (c *lruBase) unlock() {
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	for _, e := range pending {
+		// raw callback keeps the hashicorp lru contract: stored item, not fired on replace
+		if c.opts.rawEvict != nil && e.item.reason != EvictReplaced {
+			c.opts.rawEvict(e.key, e.item)
+		}
+		strKey, _ := e.key.(string)
+		c.fireEvict(strKey, e.item.val, e.item.reason)
+	}
+}
+
+// addBytes add bytes of item, sign is 1 or -1
+func (c *lruBase) addBytes(strKey string, valIf interface{}, sign int64) {
+	if c.sizeOf != nil {
+		atomic.AddInt64(&c.bytes, sign*c.sizeOf(strKey, valIf))
+	}
+}
+
+func (c *lruBase) fireEvict(strKey string, valIf interface{}, reason EvictReason) {
+	c.evictMu.RLock()
+	evictFuncs := c.evictFuncs
+	c.evictMu.RUnlock()
+	for _, fn := range evictFuncs {
+		fn(strKey, valIf, reason)
+	}
+}
+
+// AddEvictFunc add evict callback
+func (c *lruBase) AddEvictFunc(fn EvictFunc) {
+	c.evictMu.Lock()
+	evictFuncs := make([]EvictFunc, 0, len(c.evictFuncs)+1)
+	evictFuncs = append(evictFuncs, c.evictFuncs...)
+	c.evictFuncs = append(evictFuncs, fn)
+	c.evictMu.Unlock()
+}
+
+func (c *lruBase) get(strKey string) (interface{}, error) {
+	now := c.opts.clock.Now().UnixNano()
+	c.mu.Lock()
+	defer c.unlock()
+	valIf, ok := c.lru.Get(strKey)
+	if !ok {
+		if val, ok := c.pinned[strKey]; ok {
+			return val, nil
+		}
+		return nil, ErrNotFound
+	}
+	item := valIf.(*lruItem)
+	// check ttl and idle timeout
+	if item.expired(now) {
+		c.remove(strKey, item, EvictExpired)
+		return nil, ErrNotFound
+	}
+	item.touch(now)
+	return item.val, nil
+}
+
+func (c *lruBase) set(strKey string, valIf interface{}, opt ExpireOpt) {
+	item := &lruItem{
+		val:       valIf,
+		lruExpire: newLRUExpire(opt, c.opts.clock.Now().UnixNano()),
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	if oldIf, ok := c.lru.Peek(strKey); ok {
+		c.remove(strKey, oldIf.(*lruItem), EvictReplaced)
+	}
+	delete(c.pinned, strKey)
+	c.addBytes(strKey, valIf, 1)
+	if c.scan != nil && (item.expireTs > 0 || item.idle > 0) {
+		item.scanElem = c.scan.PushBack(strKey)
+	}
+	c.lru.Add(strKey, item)
+}
+
+// SetPinned set pinned value, replace existing value of key
+func (c *lruBase) SetPinned(ctx context.Context, strKey string, valIf interface{}) error {
+	c.mu.Lock()
+	defer c.unlock()
+	c.pin(strKey, valIf)
+	return nil
+}
+
+// AddPinned set pinned value if key is absent, return value kept by cache
+func (c *lruBase) AddPinned(ctx context.Context, strKey string, valIf interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	if val, ok := c.pinned[strKey]; ok {
+		return val, nil
+	}
+	if item, ok := c.peekItem(strKey); ok && !item.expired(c.opts.clock.Now().UnixNano()) {
+		// set before without pin, pin current value
+		valIf = item.val
+	}
+	c.pin(strKey, valIf)
+	return valIf, nil
+}
+
+// pin move key out of lru, mu held
+func (c *lruBase) pin(strKey string, valIf interface{}) {
+	if item, ok := c.peekItem(strKey); ok {
+		c.remove(strKey, item, EvictReplaced)
+	}
+	if c.pinned == nil {
+		c.pinned = make(map[string]interface{})
+	}
+	c.pinned[strKey] = valIf
+}
+
+// remove remove item with reason, mu held
+func (c *lruBase) remove(key interface{}, item *lruItem, reason EvictReason) {
+	item.reason = reason
+	c.lru.Remove(key)
+}
+
+// peekItem item of key without updating recency, mu held
+func (c *lruBase) peekItem(key interface{}) (*lruItem, bool) {
+	valIf, ok := c.lru.Peek(key)
+	if !ok {
+		return nil, false
+	}
+	return valIf.(*lruItem), true
+}
+
+// keys snapshot of lru keys from oldest to newest
+func (c *lruBase) keys() []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Keys()
+}
+
+// pinnedKeys snapshot of pinned keys
+func (c *lruBase) pinnedKeys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.pinned))
+	for strKey := range c.pinned {
+		keys = append(keys, strKey)
+	}
+	return keys
+}
+
+// removeExpired scan at most iMaxScan keys which may expire from the cursor,
+// remove expired entries, restart from front after the last key
+func (c *lruBase) removeExpired(iMaxScan int) int {
+	if c.scan == nil {
+		return 0
+	}
+	cnt := 0
+	now := c.opts.clock.Now().UnixNano()
+	c.mu.Lock()
+	defer c.unlock()
+	e := c.scanNext
+	if e == nil {
+		e = c.scan.Front()
+	}
+	for i := 0; i < iMaxScan && e != nil; i++ {
+		next := e.Next()
+		if item, ok := c.peekItem(e.Value); ok && item.expired(now) {
+			c.remove(e.Value, item, EvictExpired)
+			cnt++
+		}
+		e = next
+	}
+	c.scanNext = e
+	return cnt
+}
+
+// Peek get value and remaining ttl, 0 ttl means never expire,
+// idle timeout is taken into account
+func (c *lruBase) Peek(ctx context.Context, strKey string) (interface{}, time.Duration, error) {
+	now := c.opts.clock.Now().UnixNano()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.peekItem(strKey)
+	if !ok {
+		if val, ok := c.pinned[strKey]; ok {
+			return val, 0, nil
+		}
+		return nil, 0, ErrNotFound
+	}
+	if item.expired(now) {
+		return nil, 0, ErrNotFound
+	}
+	return item.val, time.Duration(item.remain(now)), nil
+}
+
+// Del del
+func (c *lruBase) Del(ctx context.Context, strKey string) error {
+	c.mu.Lock()
+	defer c.unlock()
+	if item, ok := c.peekItem(strKey); ok {
+		c.remove(strKey, item, EvictDeleted)
+	}
+	delete(c.pinned, strKey)
+	return nil
+}
+
+// IsErrNotFound is not found err
+func (c *lruBase) IsErrNotFound(err error) bool {
+	return err == ErrNotFound
+}
+
+// Range range keys, pinned keys included, stop when fn return false
+func (c *lruBase) Range(ctx context.Context, fn func(string) bool) error {
+	for _, strKey := range c.pinnedKeys() {
+		if !fn(strKey) {
+			return nil
+		}
+	}
+	for _, keyIf := range c.keys() {
+		strKey, ok := keyIf.(string)
+		if !ok {
+			continue
+		}
+		if !fn(strKey) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// DelPrefix del keys with prefix, pinned keys included, return del cnt
+func (c *lruBase) DelPrefix(ctx context.Context, strPrefix string) (int, error) {
+	cnt := 0
+	c.mu.Lock()
+	defer c.unlock()
+	for strKey := range c.pinned {
+		if strings.HasPrefix(strKey, strPrefix) {
+			delete(c.pinned, strKey)
+			cnt++
+		}
+	}
+	for _, keyIf := range c.lru.Keys() {
+		strKey, ok := keyIf.(string)
+		if !ok || !strings.HasPrefix(strKey, strPrefix) {
+			continue
+		}
+		if item, ok := c.peekItem(keyIf); ok {
+			c.remove(keyIf, item, EvictDeleted)
+			cnt++
+		}
+	}
+	return cnt, nil
+}
+
+// Len item cnt, include pinned items and expired items not removed yet
+func (c *lruBase) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len() + len(c.pinned)
+}
+
+// Stat backend stats
+func (c *lruBase) Stat() BackendStats {
+	return BackendStats{
+		Len:         int64(c.Len()),
+		Capacity:    int64(c.capacity),
+		Bytes:       atomic.LoadInt64(&c.bytes),
+		Evictions:   atomic.LoadInt64(&c.evictions),
+		Expirations: atomic.LoadInt64(&c.expirations),
+	}
+}
+
+// Close stop janitor
+func (c *lruBase) Close() error {
+	if c.janitor != nil {
+		c.janitor.Close()
+	}
+	return nil
+}
+
+// lruExpire expire info of lru item
+type lruExpire struct {
+	expireTs int64 // absolute expire ts, unix nano
+	idle     int64 // idle timeout, nano
+	accessTs int64 // last access ts, unix nano, atomic
+}
+
+func newLRUExpire(opt ExpireOpt, now int64) lruExpire {
+	e := lruExpire{
+		idle:     int64(opt.Idle),
+		accessTs: now,
+	}
+	if opt.TTL > 0 {
+		e.expireTs = now + int64(opt.TTL)
+	}
+	return e
+}
+
+// expired check absolute ttl and idle timeout
+func (e *lruExpire) expired(now int64) bool {
+	if e.expireTs > 0 && now > e.expireTs {
+		return true
+	}
+	if e.idle > 0 && now > atomic.LoadInt64(&e.accessTs)+e.idle {
+		return true
+	}
+	return false
+}
+
+// remain remaining nano before expire, 0 means never expire
+func (e *lruExpire) remain(now int64) int64 {
+	var remain int64
+	if e.expireTs > 0 {
+		remain = e.expireTs - now
+	}
+	if e.idle > 0 {
+		idleRemain := atomic.LoadInt64(&e.accessTs) + e.idle - now
+		if remain == 0 || idleRemain < remain {
+			remain = idleRemain
+		}
+	}
+	return remain
+}
+
+// touch renew idle timeout
+func (e *lruExpire) touch(now int64) {
+	if e.idle > 0 {
+		atomic.StoreInt64(&e.accessTs, now)
+	}
+}
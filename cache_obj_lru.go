@@ -3,8 +3,6 @@ package icache
 import (
 	"context"
 	"time"
-
-	lru "github.com/hashicorp/golang-lru"
 )
 
 /*
@@ -16,62 +14,38 @@ import (
 
 // LRUObjCache lru obj cache
 type LRUObjCache struct {
-	lru *lru.Cache
+	*lruBase
 }
 
 // NewLRUObjCache new lru cache
 func NewLRUObjCache(iSize int) CacheIf {
-	return NewLRUObjCacheWithEvict(iSize, nil)
+	return NewLRUObjCacheWithOpts(iSize)
 }
 
-// NewLRUObjCacheWithEvict new lru cache
+// NewLRUObjCacheWithEvict new lru cache, onEvicted receives the stored item on eviction and removal
+//
+// Deprecated: use NewLRUObjCacheWithOpts and SetLRUEvictFunc, which report evict reason
 func NewLRUObjCacheWithEvict(iSize int, onEvicted func(key interface{}, value interface{})) CacheIf {
-	cache, err := lru.NewWithEvict(iSize, onEvicted)
-	if err != nil {
-		panic(err)
-	}
-	return &LRUObjCache{lru: cache}
+	return NewLRUObjCacheWithOpts(iSize, setLRURawEvict(onEvicted))
 }
 
-type lruObjItem struct {
-	val      interface{}
-	expireTs int64
+// NewLRUObjCacheWithOpts new lru cache with options
+func NewLRUObjCacheWithOpts(iSize int, opts ...LRUOption) CacheIf {
+	return &LRUObjCache{lruBase: newLRUBase(iSize, opts...)}
 }
 
 // Get get
 func (c *LRUObjCache) Get(ctx context.Context, strKey string) (interface{}, error) {
-	valIf, ok := c.lru.Get(strKey)
-	if !ok {
-		return nil, ErrNotFound
-	}
-	item := valIf.(*lruObjItem)
-	// check ttl
-	if item.expireTs > 0 && time.Now().Unix() > item.expireTs {
-		c.lru.Remove(strKey)
-		return nil, ErrNotFound
-	}
-	return item.val, nil
+	return c.get(strKey)
 }
 
 // Set set
 func (c *LRUObjCache) Set(ctx context.Context, strKey string, valIf interface{}, iTTL int32) error {
-	item := &lruObjItem{
-		val: valIf,
-	}
-	if iTTL > 0 {
-		item.expireTs = time.Now().Unix() + int64(iTTL)
-	}
-	c.lru.Add(strKey, item)
-	return nil
+	return c.SetWithExpire(ctx, strKey, valIf, ExpireOpt{TTL: time.Duration(iTTL) * time.Second})
 }
 
-// Del del
-func (c *LRUObjCache) Del(ctx context.Context, strKey string) error {
-	c.lru.Remove(strKey)
+// SetWithExpire set with expire option
+func (c *LRUObjCache) SetWithExpire(ctx context.Context, strKey string, valIf interface{}, opt ExpireOpt) error {
+	c.set(strKey, valIf, opt)
 	return nil
 }
-
-// IsErrNotFound is not found err
-func (c *LRUObjCache) IsErrNotFound(err error) bool {
-	return err == ErrNotFound
-}
@@ -0,0 +1,565 @@
+package icache
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRingShards = 16
+
+	// entry header: hash uint64, expire ts int64, key len uint16, value len uint32
+	ringHeaderSize = 8 + 8 + 2 + 4
+	ringMaxKeyLen  = 1<<16 - 1
+)
+
+// RingOption ring byte cache option
+type RingOption struct {
+	f func(o *ringOptions)
+}
+
+type ringOptions struct {
+	shards int
+	clock  Clock
+}
+
+// SetRingShards set shard cnt, rounded up to power of 2, default 16
+func SetRingShards(iShards int) RingOption {
+	return RingOption{func(o *ringOptions) {
+		o.shards = iShards
+	}}
+}
+
+// SetRingClock set clock of ttl, default time package
+func SetRingClock(clock Clock) RingOption {
+	return RingOption{func(o *ringOptions) {
+		o.clock = clock
+	}}
+}
+
+// RingByteCache byte cache storing entries in preallocated ring buffers,
+// the GC only scans one index map of integers per shard.
+//
+// Entries are evicted in FIFO order when the ring is full, deleted and overwritten
+// entries hold ring space until evicted. Keys with the same 64-bit hash replace each other.
+// Get returns a copy of value, GetInto reuses caller buffer. Idle timeout is not supported,
+// pinned entries are kept out of the ring.
+type RingByteCache struct {
+	shards   []*ringShard
+	mask     uint64
+	clock    Clock
+	capacity int64
+
+	evictMu    sync.RWMutex
+	evictFuncs []EvictFunc
+	counters   []ringCounter
+	hasEvict   int32 // atomic, collect evicted entries only if callbacks added
+	hasCount   int32 // atomic, match evicted keys only if counters added
+}
+
+// ringCounter evict counter of keys with prefix
+type ringCounter struct {
+	prefix string
+	fn     EvictCountFunc
+}
+
+// ringShard ring buffer and hash index
+type ringShard struct {
+	mu      sync.RWMutex
+	index   map[uint64]uint32 // key hash -> entry offset in ring
+	ring    []byte
+	head    uint64            // logical offset of oldest entry
+	tail    uint64            // logical offset of next entry
+	pinned  map[string][]byte // pinned entries, out of ring
+	pending []ringEvicted     // removed under lock, callbacks fire after unlock
+	owner   *RingByteCache
+
+	bytes       int64 // atomic, live entry bytes
+	evictions   int64 // atomic
+	expirations int64 // atomic
+}
+
+// ringEvicted entry removed under shard lock
+type ringEvicted struct {
+	key    string
+	val    []byte
+	reason EvictReason
+}
+
+// NewRingByteCache new ring byte cache using iMaxBytes ring buffers in total
+func NewRingByteCache(iMaxBytes int, opts ...RingOption) CacheIf {
+	o := ringOptions{shards: defaultRingShards, clock: realClock{}}
+	for _, opt := range opts {
+		opt.f(&o)
+	}
+	iShards := 1
+	for iShards < o.shards {
+		iShards <<= 1
+	}
+	shardSize := iMaxBytes / iShards
+	if shardSize < ringHeaderSize || uint64(shardSize) > 1<<32 {
+		panic(fmt.Sprintf("invalid ring shard size %d", shardSize))
+	}
+	c := &RingByteCache{
+		shards:   make([]*ringShard, iShards),
+		mask:     uint64(iShards - 1),
+		clock:    o.clock,
+		capacity: int64(shardSize * iShards),
+	}
+	for i := range c.shards {
+		c.shards[i] = &ringShard{
+			index: make(map[uint64]uint32),
+			ring:  make([]byte, shardSize),
+			owner: c,
+		}
+	}
+	return c
+}
+
+// ringHash fnv-1a 64, no allocation
+func ringHash(strKey string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(strKey); i++ {
+		h ^= uint64(strKey[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (c *RingByteCache) shard(hash uint64) *ringShard {
+	return c.shards[hash&c.mask]
+}
+
+// unlock release write lock of shard, then fire callbacks of entries removed while it was held
+func (c *RingByteCache) unlock(s *ringShard) {
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	c.evictMu.RLock()
+	evictFuncs := c.evictFuncs
+	c.evictMu.RUnlock()
+	for _, e := range pending {
+		for _, fn := range evictFuncs {
+			fn(e.key, e.val, e.reason)
+		}
+	}
+}
+
+// AddEvictFunc add evict callback, evicted values are copied out of ring for it
+func (c *RingByteCache) AddEvictFunc(fn EvictFunc) {
+	c.evictMu.Lock()
+	evictFuncs := make([]EvictFunc, 0, len(c.evictFuncs)+1)
+	evictFuncs = append(evictFuncs, c.evictFuncs...)
+	c.evictFuncs = append(evictFuncs, fn)
+	c.evictMu.Unlock()
+	atomic.StoreInt32(&c.hasEvict, 1)
+}
+
+// AddEvictCountFunc add evict counter of keys with prefix, keys and values are not copied
+func (c *RingByteCache) AddEvictCountFunc(strPrefix string, fn EvictCountFunc) {
+	c.evictMu.Lock()
+	counters := make([]ringCounter, 0, len(c.counters)+1)
+	counters = append(counters, c.counters...)
+	c.counters = append(counters, ringCounter{prefix: strPrefix, fn: fn})
+	c.evictMu.Unlock()
+	atomic.StoreInt32(&c.hasCount, 1)
+}
+
+// Get get, return a copy of value
+func (c *RingByteCache) Get(ctx context.Context, strKey string) (interface{}, error) {
+	val, err := c.GetInto(ctx, strKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+// GetInto append value to dst and return the extended slice,
+// no allocation if dst has enough capacity
+func (c *RingByteCache) GetInto(ctx context.Context, strKey string, dst []byte) ([]byte, error) {
+	hash := ringHash(strKey)
+	s := c.shard(hash)
+	now := c.clock.Now().UnixNano()
+	s.mu.RLock()
+	if len(s.pinned) > 0 {
+		if val, ok := s.pinned[strKey]; ok {
+			dst = append(dst, val...)
+			s.mu.RUnlock()
+			return dst, nil
+		}
+	}
+	off, ok := s.index[hash]
+	if !ok {
+		s.mu.RUnlock()
+		return dst, ErrNotFound
+	}
+	var header [ringHeaderSize]byte
+	s.readAt(header[:], off)
+	expireTs := int64(binary.LittleEndian.Uint64(header[8:]))
+	keyLen := uint32(binary.LittleEndian.Uint16(header[16:]))
+	valLen := int(binary.LittleEndian.Uint32(header[18:]))
+	if !s.keyEqual(off, keyLen, strKey) {
+		s.mu.RUnlock()
+		return dst, ErrNotFound
+	}
+	if expireTs > 0 && now > expireTs {
+		s.mu.RUnlock()
+		s.mu.Lock()
+		if cur, ok := s.index[hash]; ok && cur == off {
+			s.drop(hash, off, EvictExpired)
+		}
+		c.unlock(s)
+		return dst, ErrNotFound
+	}
+	n := len(dst)
+	if cap(dst)-n < valLen {
+		grown := make([]byte, n, n+valLen)
+		copy(grown, dst)
+		dst = grown
+	}
+	dst = dst[:n+valLen]
+	s.readAt(dst[n:], s.wrap(uint64(off)+ringHeaderSize+uint64(keyLen)))
+	s.mu.RUnlock()
+	return dst, nil
+}
+
+// Set set
+func (c *RingByteCache) Set(ctx context.Context, strKey string, valIf interface{}, iTTL int32) error {
+	return c.SetWithExpire(ctx, strKey, valIf, ExpireOpt{TTL: time.Duration(iTTL) * time.Second})
+}
+
+// SetWithExpire set with ttl, idle timeout is ErrNotSupport
+func (c *RingByteCache) SetWithExpire(ctx context.Context, strKey string, valIf interface{}, opt ExpireOpt) error {
+	if opt.Idle > 0 {
+		return ErrNotSupport
+	}
+	var expireTs int64
+	if opt.TTL > 0 {
+		expireTs = c.clock.Now().Add(opt.TTL).UnixNano()
+	}
+	switch val := valIf.(type) {
+	case []byte:
+		return c.set(strKey, val, "", expireTs)
+	case string:
+		return c.set(strKey, nil, val, expireTs)
+	default:
+		return fmt.Errorf("RingByteCache only support []byte and string type")
+	}
+}
+
+// set set []byte or string value without conversion
+func (c *RingByteCache) set(strKey string, val []byte, strVal string, expireTs int64) error {
+	valLen := len(val) + len(strVal)
+	size := uint64(ringHeaderSize + len(strKey) + valLen)
+	hash := ringHash(strKey)
+	s := c.shard(hash)
+	if len(strKey) > ringMaxKeyLen || size > uint64(len(s.ring)) {
+		return fmt.Errorf("RingByteCache entry too large, key=%s size=%d", strKey, size)
+	}
+	var header [ringHeaderSize]byte
+	binary.LittleEndian.PutUint64(header[0:], hash)
+	binary.LittleEndian.PutUint64(header[8:], uint64(expireTs))
+	binary.LittleEndian.PutUint16(header[16:], uint16(len(strKey)))
+	binary.LittleEndian.PutUint32(header[18:], uint32(valLen))
+
+	s.mu.Lock()
+	defer c.unlock(s)
+	for s.tail+size-s.head > uint64(len(s.ring)) {
+		s.evictHead()
+	}
+	if off, ok := s.index[hash]; ok {
+		reason := EvictReplaced
+		if s.readKey(off) != strKey {
+			// hash collision
+			reason = EvictCapacity
+		}
+		s.drop(hash, off, reason)
+	}
+	delete(s.pinned, strKey)
+	off := s.wrap(s.tail)
+	s.writeAt(header[:], off)
+	s.writeStringAt(strKey, s.wrap(s.tail+ringHeaderSize))
+	valOff := s.wrap(s.tail + ringHeaderSize + uint64(len(strKey)))
+	if val != nil {
+		s.writeAt(val, valOff)
+	} else {
+		s.writeStringAt(strVal, valOff)
+	}
+	s.tail += size
+	s.index[hash] = off
+	atomic.AddInt64(&s.bytes, int64(size))
+	return nil
+}
+
+// SetPinned set pinned value, replace existing value of key
+func (c *RingByteCache) SetPinned(ctx context.Context, strKey string, valIf interface{}) error {
+	val, err := ringBytes(valIf)
+	if err != nil {
+		return err
+	}
+	s := c.shard(ringHash(strKey))
+	s.mu.Lock()
+	defer c.unlock(s)
+	s.pin(strKey, val)
+	return nil
+}
+
+// AddPinned set pinned value if key is absent, return value kept by cache
+func (c *RingByteCache) AddPinned(ctx context.Context, strKey string, valIf interface{}) (interface{}, error) {
+	val, err := ringBytes(valIf)
+	if err != nil {
+		return nil, err
+	}
+	hash := ringHash(strKey)
+	s := c.shard(hash)
+	now := c.clock.Now().UnixNano()
+	s.mu.Lock()
+	defer c.unlock(s)
+	if kept, ok := s.pinned[strKey]; ok {
+		return append([]byte(nil), kept...), nil
+	}
+	if off, ok := s.index[hash]; ok && s.readKey(off) == strKey {
+		var header [ringHeaderSize]byte
+		s.readAt(header[:], off)
+		if expireTs := int64(binary.LittleEndian.Uint64(header[8:])); expireTs == 0 || now <= expireTs {
+			// set before without pin, pin current value
+			val = s.readValue(off)
+		}
+	}
+	s.pin(strKey, val)
+	return append([]byte(nil), val...), nil
+}
+
+// ringBytes copy []byte or string value
+func ringBytes(valIf interface{}) ([]byte, error) {
+	switch val := valIf.(type) {
+	case []byte:
+		return append([]byte(nil), val...), nil
+	case string:
+		return []byte(val), nil
+	default:
+		return nil, fmt.Errorf("RingByteCache only support []byte and string type")
+	}
+}
+
+// Del del
+func (c *RingByteCache) Del(ctx context.Context, strKey string) error {
+	hash := ringHash(strKey)
+	s := c.shard(hash)
+	s.mu.Lock()
+	if off, ok := s.index[hash]; ok && s.readKey(off) == strKey {
+		s.drop(hash, off, EvictDeleted)
+	}
+	delete(s.pinned, strKey)
+	c.unlock(s)
+	return nil
+}
+
+// IsErrNotFound is not found err
+func (c *RingByteCache) IsErrNotFound(err error) bool {
+	return err == ErrNotFound
+}
+
+// Range range keys, pinned keys included, stop when fn return false
+func (c *RingByteCache) Range(ctx context.Context, fn func(string) bool) error {
+	for _, s := range c.shards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.mu.RLock()
+		keys := make([]string, 0, len(s.pinned)+len(s.index))
+		for strKey := range s.pinned {
+			keys = append(keys, strKey)
+		}
+		for _, off := range s.index {
+			keys = append(keys, s.readKey(off))
+		}
+		s.mu.RUnlock()
+		for _, strKey := range keys {
+			if !fn(strKey) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// DelPrefix del keys with prefix, pinned keys included, return del cnt
+func (c *RingByteCache) DelPrefix(ctx context.Context, strPrefix string) (int, error) {
+	cnt := 0
+	for _, s := range c.shards {
+		if err := ctx.Err(); err != nil {
+			return cnt, err
+		}
+		s.mu.Lock()
+		for strKey := range s.pinned {
+			if strings.HasPrefix(strKey, strPrefix) {
+				delete(s.pinned, strKey)
+				cnt++
+			}
+		}
+		for hash, off := range s.index {
+			if strings.HasPrefix(s.readKey(off), strPrefix) {
+				s.drop(hash, off, EvictDeleted)
+				cnt++
+			}
+		}
+		c.unlock(s)
+	}
+	return cnt, nil
+}
+
+// Len item cnt, include pinned items and expired items not removed yet
+func (c *RingByteCache) Len() int {
+	cnt := 0
+	for _, s := range c.shards {
+		s.mu.RLock()
+		cnt += len(s.index) + len(s.pinned)
+		s.mu.RUnlock()
+	}
+	return cnt
+}
+
+// Stat backend stats, Capacity is ring bytes, pinned entries are counted in Len only
+func (c *RingByteCache) Stat() BackendStats {
+	stat := BackendStats{Capacity: c.capacity}
+	for _, s := range c.shards {
+		s.mu.RLock()
+		stat.Len += int64(len(s.index) + len(s.pinned))
+		s.mu.RUnlock()
+		stat.Bytes += atomic.LoadInt64(&s.bytes)
+		stat.Evictions += atomic.LoadInt64(&s.evictions)
+		stat.Expirations += atomic.LoadInt64(&s.expirations)
+	}
+	return stat
+}
+
+// evictHead drop oldest entry, lock held
+func (s *ringShard) evictHead() {
+	var header [ringHeaderSize]byte
+	off := s.wrap(s.head)
+	s.readAt(header[:], off)
+	hash := binary.LittleEndian.Uint64(header[0:])
+	keyLen := uint64(binary.LittleEndian.Uint16(header[16:]))
+	valLen := uint64(binary.LittleEndian.Uint32(header[18:]))
+	if cur, ok := s.index[hash]; ok && cur == off {
+		s.drop(hash, off, EvictCapacity)
+	}
+	s.head += ringHeaderSize + keyLen + valLen
+}
+
+// drop remove live entry at off from index, account it and collect it for callbacks, lock held
+func (s *ringShard) drop(hash uint64, off uint32, reason EvictReason) {
+	var header [ringHeaderSize]byte
+	s.readAt(header[:], off)
+	size := ringHeaderSize + int64(binary.LittleEndian.Uint16(header[16:])) + int64(binary.LittleEndian.Uint32(header[18:]))
+	delete(s.index, hash)
+	atomic.AddInt64(&s.bytes, -size)
+	switch reason {
+	case EvictCapacity:
+		atomic.AddInt64(&s.evictions, 1)
+	case EvictExpired:
+		atomic.AddInt64(&s.expirations, 1)
+	}
+	if atomic.LoadInt32(&s.owner.hasCount) != 0 {
+		s.owner.evictMu.RLock()
+		counters := s.owner.counters
+		s.owner.evictMu.RUnlock()
+		for _, counter := range counters {
+			if s.keyHasPrefix(off, counter.prefix) {
+				counter.fn(reason)
+			}
+		}
+	}
+	if atomic.LoadInt32(&s.owner.hasEvict) != 0 {
+		s.pending = append(s.pending, ringEvicted{key: s.readKey(off), val: s.readValue(off), reason: reason})
+	}
+}
+
+// pin move key out of ring, lock held
+func (s *ringShard) pin(strKey string, val []byte) {
+	hash := ringHash(strKey)
+	if off, ok := s.index[hash]; ok && s.readKey(off) == strKey {
+		s.drop(hash, off, EvictReplaced)
+	}
+	if s.pinned == nil {
+		s.pinned = make(map[string][]byte)
+	}
+	s.pinned[strKey] = val
+}
+
+func (s *ringShard) wrap(logical uint64) uint32 {
+	return uint32(logical % uint64(len(s.ring)))
+}
+
+// readAt read len(dst) bytes at off, wrap at ring end
+func (s *ringShard) readAt(dst []byte, off uint32) {
+	n := copy(dst, s.ring[off:])
+	copy(dst[n:], s.ring)
+}
+
+func (s *ringShard) writeAt(src []byte, off uint32) {
+	n := copy(s.ring[off:], src)
+	copy(s.ring, src[n:])
+}
+
+func (s *ringShard) writeStringAt(src string, off uint32) {
+	n := copy(s.ring[off:], src)
+	copy(s.ring, src[n:])
+}
+
+// readValue copy value of entry at off
+func (s *ringShard) readValue(off uint32) []byte {
+	var header [ringHeaderSize]byte
+	s.readAt(header[:], off)
+	keyLen := uint64(binary.LittleEndian.Uint16(header[16:]))
+	val := make([]byte, binary.LittleEndian.Uint32(header[18:]))
+	s.readAt(val, s.wrap(uint64(off)+ringHeaderSize+keyLen))
+	return val
+}
+
+// readKey read key of entry at off
+func (s *ringShard) readKey(off uint32) string {
+	var lenBuf [2]byte
+	s.readAt(lenBuf[:], s.wrap(uint64(off)+16))
+	key := make([]byte, binary.LittleEndian.Uint16(lenBuf[:]))
+	s.readAt(key, s.wrap(uint64(off)+ringHeaderSize))
+	return string(key)
+}
+
+// keyHasPrefix check key of entry at off has prefix without allocation
+func (s *ringShard) keyHasPrefix(off uint32, strPrefix string) bool {
+	var lenBuf [2]byte
+	s.readAt(lenBuf[:], s.wrap(uint64(off)+16))
+	if int(binary.LittleEndian.Uint16(lenBuf[:])) < len(strPrefix) {
+		return false
+	}
+	// compare the first len(strPrefix) bytes
+	return s.keyEqual(off, uint32(len(strPrefix)), strPrefix)
+}
+
+// keyEqual compare key of entry at off with strKey without allocation
+func (s *ringShard) keyEqual(off uint32, keyLen uint32, strKey string) bool {
+	if int(keyLen) != len(strKey) {
+		return false
+	}
+	pos := s.wrap(uint64(off) + ringHeaderSize)
+	for i := 0; i < len(strKey); i++ {
+		if s.ring[pos] != strKey[i] {
+			return false
+		}
+		pos++
+		if int(pos) == len(s.ring) {
+			pos = 0
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package icache
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock time source of ttl, idle timeout, janitor and windows,
+// replace with FakeClock in tests to avoid sleep
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker ticker created by Clock
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// realClock default clock, time package
+type realClock struct{}
+
+// Now now
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker new ticker
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+// Chan tick chan
+func (t realTicker) Chan() <-chan time.Time {
+	return t.C
+}
+
+// FakeClock manual clock for tests, time only moves by Advance and Set
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFakeClock new fake clock start at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now now
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance move time forward by d and fire due tickers
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.setLocked(c.now.Add(d))
+	c.mu.Unlock()
+}
+
+// Set set time, fire due tickers if time moves forward
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	c.setLocked(now)
+	c.mu.Unlock()
+}
+
+func (c *FakeClock) setLocked(now time.Time) {
+	c.now = now
+	tickers := c.tickers[:0]
+	for _, t := range c.tickers {
+		if t.stopped {
+			continue
+		}
+		if !now.Before(t.next) {
+			// like time.Ticker, drop ticks for slow receivers
+			select {
+			case t.ch <- now:
+			default:
+			}
+			for !now.Before(t.next) {
+				t.next = t.next.Add(t.period)
+			}
+		}
+		tickers = append(tickers, t)
+	}
+	c.tickers = tickers
+}
+
+// NewTicker new ticker fired by Advance and Set
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{
+		clock:  c,
+		ch:     make(chan time.Time, 1),
+		period: d,
+		next:   c.now.Add(d),
+	}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+type fakeTicker struct {
+	clock   *FakeClock
+	ch      chan time.Time
+	period  time.Duration
+	next    time.Time // guarded by clock.mu
+	stopped bool      // guarded by clock.mu
+}
+
+// Chan tick chan
+func (t *fakeTicker) Chan() <-chan time.Time {
+	return t.ch
+}
+
+// Stop stop ticker
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	t.stopped = true
+	t.clock.mu.Unlock()
+}
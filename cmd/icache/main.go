@@ -0,0 +1,333 @@
+// Command icache inspects and converts icache snapshot files,
+// and replays access traces to size caches.
+//
+//	icache keys [-prefix p] file
+//	icache show [-prefix p] [-format text|hex|json] file
+//	icache stats [-prefix p] file
+//	icache convert -to version in out
+//	icache sim -sizes n1,n2 [-ttl d] trace
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/iglev/icache/sim"
+	"github.com/iglev/icache/snapshot"
+)
+
+// stdout command output, replaced in tests
+var stdout io.Writer = os.Stdout
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "keys":
+		err = cmdKeys(os.Args[2:])
+	case "show":
+		err = cmdShow(os.Args[2:])
+	case "stats":
+		err = cmdStats(os.Args[2:])
+	case "convert":
+		err = cmdConvert(os.Args[2:])
+	case "sim":
+		err = cmdSim(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "icache %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage:
+  icache keys [-prefix p] file                      list keys
+  icache show [-prefix p] [-format text|hex|json] file  show keys, values and ttl
+  icache stats [-prefix p] file                     ttl distribution and size histograms
+  icache convert -to version in out                 convert snapshot version
+  icache sim -sizes n1,n2 [-ttl d] trace            replay trace against lru caches of sizes
+`)
+}
+
+// forEach read records with key prefix
+func forEach(strFile string, strPrefix string, fn func(rec *snapshot.Record) error) error {
+	f, err := os.Open(strFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sr, err := snapshot.NewReader(f)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := sr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(rec.Key, strPrefix) {
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+func parseFileArgs(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("need one snapshot file")
+	}
+	return fs.Arg(0), nil
+}
+
+func cmdKeys(args []string) error {
+	fs := flag.NewFlagSet("keys", flag.ExitOnError)
+	strPrefix := fs.String("prefix", "", "key prefix")
+	strFile, err := parseFileArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	return forEach(strFile, *strPrefix, func(rec *snapshot.Record) error {
+		_, err := fmt.Fprintln(stdout, rec.Key)
+		return err
+	})
+}
+
+func cmdShow(args []string) error {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	strPrefix := fs.String("prefix", "", "key prefix")
+	strFormat := fs.String("format", "text", "value format: text, hex or json")
+	strFile, err := parseFileArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return forEach(strFile, *strPrefix, func(rec *snapshot.Record) error {
+		val, err := formatValue(rec, *strFormat)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(stdout, "%s\t%s\tttl=%s\tidle=%s\t%s\n", rec.Key, rec.Kind, formatTTL(rec.TTL(now)), rec.Idle, val)
+		return err
+	})
+}
+
+func formatValue(rec *snapshot.Record, strFormat string) (string, error) {
+	switch strFormat {
+	case "text":
+		if utf8.Valid(rec.Value) {
+			return fmt.Sprintf("%q", rec.Value), nil
+		}
+		return hex.EncodeToString(rec.Value), nil
+	case "hex":
+		return hex.EncodeToString(rec.Value), nil
+	case "json":
+		if rec.Kind != snapshot.KindJSON && !json.Valid(rec.Value) {
+			b, err := json.Marshal(string(rec.Value))
+			return string(b), err
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, rec.Value, "", "  "); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	default:
+		return "", fmt.Errorf("unknown format %s", strFormat)
+	}
+}
+
+func formatTTL(ttl time.Duration) string {
+	switch {
+	case ttl == 0:
+		return "never"
+	case ttl < 0:
+		return "expired"
+	default:
+		return ttl.Round(time.Millisecond).String()
+	}
+}
+
+// ttlBuckets ttl distribution buckets
+var ttlBuckets = []struct {
+	name string
+	max  time.Duration
+}{
+	{"<1m", time.Minute},
+	{"<10m", 10 * time.Minute},
+	{"<1h", time.Hour},
+	{"<1d", 24 * time.Hour},
+	{">=1d", 1<<63 - 1},
+}
+
+func cmdStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	strPrefix := fs.String("prefix", "", "key prefix")
+	strFile, err := parseFileArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	var (
+		cnt, keyBytes, valBytes int64
+		never, expired          int64
+		ttlCnt                  = make([]int64, len(ttlBuckets))
+		kindCnt                 = make(map[snapshot.Kind]int64)
+		keySizeCnt              = make(map[int]int64) // power of 2 key size bucket
+		sizeCnt                 = make(map[int]int64) // power of 2 value size bucket
+	)
+	now := time.Now()
+	err = forEach(strFile, *strPrefix, func(rec *snapshot.Record) error {
+		cnt++
+		keyBytes += int64(len(rec.Key))
+		valBytes += int64(len(rec.Value))
+		kindCnt[rec.Kind]++
+		keySizeCnt[sizeBucket(len(rec.Key))]++
+		sizeCnt[sizeBucket(len(rec.Value))]++
+		ttl := rec.TTL(now)
+		switch {
+		case ttl == 0:
+			never++
+		case ttl < 0:
+			expired++
+		default:
+			for i, b := range ttlBuckets {
+				if ttl < b.max {
+					ttlCnt[i]++
+					break
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "entries: %d\nkey bytes: %d\nvalue bytes: %d\n", cnt, keyBytes, valBytes)
+	fmt.Fprintf(stdout, "\nkinds:\n")
+	for _, kind := range []snapshot.Kind{snapshot.KindBytes, snapshot.KindString, snapshot.KindJSON} {
+		fmt.Fprintf(stdout, "  %-8s %d\n", kind, kindCnt[kind])
+	}
+	fmt.Fprintf(stdout, "\nttl:\n  %-8s %d\n  %-8s %d\n", "never", never, "expired", expired)
+	for i, b := range ttlBuckets {
+		fmt.Fprintf(stdout, "  %-8s %d\n", b.name, ttlCnt[i])
+	}
+	printSizes("key size", keySizeCnt)
+	printSizes("value size", sizeCnt)
+	return nil
+}
+
+// printSizes print size histogram in bucket order
+func printSizes(strName string, sizeCnt map[int]int64) {
+	fmt.Fprintf(stdout, "\n%s:\n", strName)
+	buckets := make([]int, 0, len(sizeCnt))
+	for b := range sizeCnt {
+		buckets = append(buckets, b)
+	}
+	sort.Ints(buckets)
+	for _, b := range buckets {
+		fmt.Fprintf(stdout, "  <%-8d %d\n", b, sizeCnt[b])
+	}
+}
+
+// sizeBucket smallest power of 2 greater than size
+func sizeBucket(size int) int {
+	b := 1
+	for b <= size {
+		b <<= 1
+	}
+	return b
+}
+
+func cmdConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	iVersion := fs.Int("to", snapshot.LatestVersion, "target snapshot version")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("need in and out file")
+	}
+	if *iVersion != snapshot.Version1 && *iVersion != snapshot.Version2 {
+		return snapshot.ErrVersion
+	}
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	cnt, err := snapshot.Convert(out, in, *iVersion)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "converted %d entries to version %d\n", cnt, *iVersion)
+	return nil
+}
+
+func cmdSim(args []string) error {
+	fs := flag.NewFlagSet("sim", flag.ExitOnError)
+	strSizes := fs.String("sizes", "1000,10000,100000", "comma separated lru capacities")
+	ttl := fs.Duration("ttl", 0, "ttl of entries, 0 never expire")
+	strFile, err := parseFileArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	var cfgs []sim.Config
+	for _, strSize := range strings.Split(*strSizes, ",") {
+		iSize, err := strconv.Atoi(strings.TrimSpace(strSize))
+		if err != nil || iSize <= 0 {
+			return fmt.Errorf("bad size %q", strSize)
+		}
+		cfgs = append(cfgs, sim.LRUObjConfig(iSize, *ttl))
+	}
+	f, err := os.Open(strFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr, err := sim.OpenTrace(f)
+	if err != nil {
+		return err
+	}
+	results, err := sim.Replay(context.Background(), tr, cfgs...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%-16s %12s %10s %10s %12s %12s\n", "config", "requests", "hit", "byte_hit", "evictions", "expirations")
+	for _, r := range results {
+		fmt.Fprintf(stdout, "%-16s %12d %10.4f %10.4f %12d %12d\n",
+			r.Name, r.Requests, r.HitRatio(), r.ByteHitRatio(), r.Evictions, r.Expirations)
+	}
+	return nil
+}
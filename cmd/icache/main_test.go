@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iglev/icache/snapshot"
+)
+
+// run run cmd and return its output
+func run(t *testing.T, cmd func([]string) error, args ...string) string {
+	var buf bytes.Buffer
+	stdout = &buf
+	defer func() {
+		stdout = os.Stdout
+	}()
+	if err := cmd(args); err != nil {
+		t.Fatalf("args=%v err=%+v", args, err)
+	}
+	return buf.String()
+}
+
+// writeSnapshot write records to a snapshot file in t.TempDir
+func writeSnapshot(t *testing.T, recs ...*snapshot.Record) string {
+	strFile := filepath.Join(t.TempDir(), "cache.snap")
+	f, err := os.Create(strFile)
+	if err != nil {
+		t.Fatalf("Create err=%+v", err)
+	}
+	defer f.Close()
+	sw, _ := snapshot.NewWriter(f, snapshot.LatestVersion)
+	for _, rec := range recs {
+		if err := sw.Write(rec); err != nil {
+			t.Fatalf("Write err=%+v", err)
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		t.Fatalf("Flush err=%+v", err)
+	}
+	return strFile
+}
+
+func testRecords() []*snapshot.Record {
+	now := time.Now()
+	return []*snapshot.Record{
+		{Key: "user:1", Kind: snapshot.KindString, Value: []byte("alice")},
+		{Key: "user:22", Kind: snapshot.KindJSON, Value: []byte(`{"a":1}`), ExpireTs: now.Add(30 * time.Minute).UnixNano()},
+		{Key: "item:333", Kind: snapshot.KindBytes, Value: bytes.Repeat([]byte{0xff}, 20), ExpireTs: now.Add(-time.Second).UnixNano()},
+	}
+}
+
+func TestKeys(t *testing.T) {
+	strFile := writeSnapshot(t, testRecords()...)
+	if out := run(t, cmdKeys, strFile); out != "user:1\nuser:22\nitem:333\n" {
+		t.Fatalf("out=%q", out)
+	}
+	if out := run(t, cmdKeys, "-prefix", "user:", strFile); out != "user:1\nuser:22\n" {
+		t.Fatalf("out=%q", out)
+	}
+	if err := cmdKeys([]string{filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Fatalf("missing file no err")
+	}
+}
+
+func TestShow(t *testing.T) {
+	strFile := writeSnapshot(t, testRecords()...)
+	out := run(t, cmdShow, strFile)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 3 ||
+		lines[0] != "user:1\tstring\tttl=never\tidle=0s\t\"alice\"" ||
+		(!strings.HasPrefix(lines[1], "user:22\tjson\tttl=29m5") && !strings.HasPrefix(lines[1], "user:22\tjson\tttl=30m0s")) ||
+		lines[2] != "item:333\tbytes\tttl=expired\tidle=0s\t"+strings.Repeat("ff", 20) {
+		t.Fatalf("out=%q", out)
+	}
+	out = run(t, cmdShow, "-prefix", "user:", "-format", "json", strFile)
+	if !strings.Contains(out, "\t\"alice\"\n") || !strings.Contains(out, "{\n  \"a\": 1\n}\n") {
+		t.Fatalf("out=%q", out)
+	}
+	if err := cmdShow([]string{"-format", "xml", strFile}); err == nil {
+		t.Fatalf("unknown format no err")
+	}
+}
+
+func TestStats(t *testing.T) {
+	strFile := writeSnapshot(t, testRecords()...)
+	out := run(t, cmdStats, strFile)
+	for _, part := range []string{
+		"entries: 3\nkey bytes: 21\nvalue bytes: 32\n",
+		"\nkinds:\n  bytes    1\n  string   1\n  json     1\n",
+		"\nttl:\n  never    1\n  expired  1\n  <1m      0\n  <10m     0\n  <1h      1\n  <1d      0\n  >=1d     0\n",
+		"\nkey size:\n  <8        2\n  <16       1\n",
+		"\nvalue size:\n  <8        2\n  <32       1\n",
+	} {
+		if !strings.Contains(out, part) {
+			t.Fatalf("part=%q not found, out=%q", part, out)
+		}
+	}
+	out = run(t, cmdStats, "-prefix", "item:", strFile)
+	if !strings.Contains(out, "\nkey size:\n  <16       1\n\nvalue size:\n  <32       1\n") {
+		t.Fatalf("out=%q", out)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	strFile := writeSnapshot(t, testRecords()...)
+	strOut := filepath.Join(t.TempDir(), "v1.snap")
+	if out := run(t, cmdConvert, "-to", "1", strFile, strOut); out != "converted 3 entries to version 1\n" {
+		t.Fatalf("out=%q", out)
+	}
+	if out := run(t, cmdKeys, strOut); out != "user:1\nuser:22\nitem:333\n" {
+		t.Fatalf("out=%q", out)
+	}
+	if err := cmdConvert([]string{"-to", "9", strFile, strOut}); err != snapshot.ErrVersion {
+		t.Fatalf("err=%+v", err)
+	}
+}
+
+func TestSim(t *testing.T) {
+	strFile := filepath.Join(t.TempDir(), "access.trace")
+	if err := os.WriteFile(strFile, []byte("a 10\nb 10\na 10\nc 10\na 10\nb 10\n"), 0644); err != nil {
+		t.Fatalf("WriteFile err=%+v", err)
+	}
+	out := run(t, cmdSim, "-sizes", "1,3", strFile)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "config") {
+		t.Fatalf("out=%q", out)
+	}
+	// cap 3 misses only the first access of a, b and c
+	if fields := strings.Fields(lines[2]); len(fields) != 6 || fields[1] != "6" || fields[2] != "0.5000" {
+		t.Fatalf("line=%q", lines[2])
+	}
+	if err := cmdSim([]string{"-sizes", "0", strFile}); err == nil {
+		t.Fatalf("bad size no err")
+	}
+}
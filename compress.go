@@ -0,0 +1,456 @@
+package icache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iglev/icache/snapshot"
+)
+
+const (
+	// CodecRaw value stored without compression
+	CodecRaw byte = 0
+	// CodecGzip gzip codec id
+	CodecGzip byte = 1
+	// CodecFlate flate codec id
+	CodecFlate byte = 2
+
+	// maxDecodeSize decoded value limit of built-in codecs, larger values are ErrCodec
+	maxDecodeSize = 64 << 20
+)
+
+var (
+	// ErrCodec unknown codec or corrupt compressed value
+	ErrCodec = fmt.Errorf("err codec")
+)
+
+// Codec value codec, ID is stored in the first byte of cached value,
+// 0 is reserved for raw values, ids below 128 are reserved for built-in codecs
+type Codec interface {
+	ID() byte
+	Encode(src []byte) ([]byte, error)
+	Decode(src []byte) ([]byte, error)
+}
+
+// gzipCodec gzip codec, writers are pooled
+type gzipCodec struct {
+	pool sync.Pool
+}
+
+// NewGzipCodec new gzip codec with compression level of compress/gzip
+func NewGzipCodec(iLevel int) Codec {
+	c := &gzipCodec{}
+	c.pool.New = func() interface{} {
+		w, err := gzip.NewWriterLevel(nil, iLevel)
+		if err != nil {
+			panic(err)
+		}
+		return w
+	}
+	return c
+}
+
+// ID codec id
+func (c *gzipCodec) ID() byte {
+	return CodecGzip
+}
+
+// Encode encode
+func (c *gzipCodec) Encode(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := c.pool.Get().(*gzip.Writer)
+	defer c.pool.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decode
+func (c *gzipCodec) Decode(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r)
+}
+
+// flateCodec flate codec, writers are pooled
+type flateCodec struct {
+	pool sync.Pool
+}
+
+// NewFlateCodec new flate codec with compression level of compress/flate
+func NewFlateCodec(iLevel int) Codec {
+	c := &flateCodec{}
+	c.pool.New = func() interface{} {
+		w, err := flate.NewWriter(nil, iLevel)
+		if err != nil {
+			panic(err)
+		}
+		return w
+	}
+	return c
+}
+
+// ID codec id
+func (c *flateCodec) ID() byte {
+	return CodecFlate
+}
+
+// Encode encode
+func (c *flateCodec) Encode(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := c.pool.Get().(*flate.Writer)
+	defer c.pool.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decode
+func (c *flateCodec) Decode(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return readLimited(r)
+}
+
+// readLimited read all of r, ErrCodec if more than maxDecodeSize bytes
+func readLimited(r io.Reader) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, maxDecodeSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxDecodeSize {
+		return nil, ErrCodec
+	}
+	return b, nil
+}
+
+// CompressStats compression counters
+type CompressStats struct {
+	SetCnt            int64 // values set
+	CompressedCnt     int64 // values stored compressed
+	RawBytes          int64 // value bytes before compression
+	StoredBytes       int64 // value bytes stored, header byte included
+	DecodeErrCnt      int64 // unknown codec or corrupt values on get
+	EncodeErrCnt      int64 // encode fail, stored raw
+	IncompressibleCnt int64 // compressed size not smaller, stored raw
+}
+
+// Ratio stored bytes / raw bytes, smaller is better
+func (s CompressStats) Ratio() float64 {
+	if s.RawBytes == 0 {
+		return 0
+	}
+	return float64(s.StoredBytes) / float64(s.RawBytes)
+}
+
+// CacheCompressStatIf optional cache interface, report compression stats
+type CacheCompressStatIf interface {
+	CompressStat() CompressStats
+}
+
+// CompressCache CacheIf wrapper compressing []byte and string values larger than threshold,
+// Get returns []byte. Other codecs passed to NewCompressCache decode values written by them,
+// so the codec can be changed without flushing the cache.
+// Optional interfaces are forwarded and work only if the wrapped cache implements them,
+// ICache checks the whole chain through CacheUnwrapIf, other callers should do the same.
+type CompressCache struct {
+	cache     CacheIf
+	codec     Codec
+	codecs    map[byte]Codec
+	threshold int
+
+	stats CompressStats // atomic
+}
+
+// NewCompressCache wrap cache, values of at least iThreshold bytes are encoded by codec
+func NewCompressCache(cache CacheIf, codec Codec, iThreshold int, decoders ...Codec) *CompressCache {
+	c := &CompressCache{
+		cache:     cache,
+		codec:     codec,
+		codecs:    make(map[byte]Codec),
+		threshold: iThreshold,
+	}
+	for _, d := range decoders {
+		c.codecs[d.ID()] = d
+	}
+	c.codecs[codec.ID()] = codec
+	return c
+}
+
+// Get get
+func (c *CompressCache) Get(ctx context.Context, strKey string) (interface{}, error) {
+	valIf, err := c.cache.Get(ctx, strKey)
+	if err != nil {
+		return nil, err
+	}
+	return c.decode(valIf)
+}
+
+// Set set
+func (c *CompressCache) Set(ctx context.Context, strKey string, valIf interface{}, iTTL int32) error {
+	val, err := c.encode(valIf)
+	if err != nil {
+		return err
+	}
+	return c.cache.Set(ctx, strKey, val, iTTL)
+}
+
+// SetWithExpire set with expire option, wrapped cache must implement CacheExpireIf
+func (c *CompressCache) SetWithExpire(ctx context.Context, strKey string, valIf interface{}, opt ExpireOpt) error {
+	expireCache, ok := c.cache.(CacheExpireIf)
+	if !ok {
+		return ErrNotSupport
+	}
+	val, err := c.encode(valIf)
+	if err != nil {
+		return err
+	}
+	return expireCache.SetWithExpire(ctx, strKey, val, opt)
+}
+
+// Del del
+func (c *CompressCache) Del(ctx context.Context, strKey string) error {
+	return c.cache.Del(ctx, strKey)
+}
+
+// IsErrNotFound is not found err
+func (c *CompressCache) IsErrNotFound(err error) bool {
+	return c.cache.IsErrNotFound(err)
+}
+
+// Peek peek and decode, wrapped cache must implement CachePeekIf
+func (c *CompressCache) Peek(ctx context.Context, strKey string) (interface{}, time.Duration, error) {
+	peekCache, ok := c.cache.(CachePeekIf)
+	if !ok {
+		return nil, 0, ErrNotSupport
+	}
+	valIf, ttl, err := peekCache.Peek(ctx, strKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	val, err := c.decode(valIf)
+	return val, ttl, err
+}
+
+// Range range keys, wrapped cache must implement CacheIterIf
+func (c *CompressCache) Range(ctx context.Context, fn func(string) bool) error {
+	iterCache, ok := c.cache.(CacheIterIf)
+	if !ok {
+		return ErrNotSupport
+	}
+	return iterCache.Range(ctx, fn)
+}
+
+// DelPrefix del keys with prefix, wrapped cache must implement CacheIterIf
+func (c *CompressCache) DelPrefix(ctx context.Context, strPrefix string) (int, error) {
+	iterCache, ok := c.cache.(CacheIterIf)
+	if !ok {
+		return 0, ErrNotSupport
+	}
+	return iterCache.DelPrefix(ctx, strPrefix)
+}
+
+// Close close wrapped cache if it implements io.Closer
+func (c *CompressCache) Close() error {
+	if closer, ok := c.cache.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Unwrap wrapped cache
+func (c *CompressCache) Unwrap() CacheIf {
+	return c.cache
+}
+
+// AddEvictFunc add evict callback receiving decoded values, nil if value is corrupt,
+// no-op if wrapped cache does not implement CacheEvictIf
+func (c *CompressCache) AddEvictFunc(fn EvictFunc) {
+	evictCache, ok := c.cache.(CacheEvictIf)
+	if !ok {
+		return
+	}
+	evictCache.AddEvictFunc(func(strKey string, valIf interface{}, reason EvictReason) {
+		val, err := c.decodeValue(valIf)
+		if err != nil {
+			val = nil
+		}
+		fn(strKey, val, reason)
+	})
+}
+
+// AddEvictCountFunc add evict counter to wrapped cache, keys are not changed by wrapper,
+// no-op if wrapped cache does not implement CacheEvictCountIf
+func (c *CompressCache) AddEvictCountFunc(strPrefix string, fn EvictCountFunc) {
+	if countCache, ok := c.cache.(CacheEvictCountIf); ok {
+		countCache.AddEvictCountFunc(strPrefix, fn)
+	}
+}
+
+// Stat stats of wrapped cache, zero if it does not implement CacheStatIf
+func (c *CompressCache) Stat() BackendStats {
+	if statCache, ok := c.cache.(CacheStatIf); ok {
+		return statCache.Stat()
+	}
+	return BackendStats{}
+}
+
+// Flush flush wrapped cache if it implements CacheFlushIf
+func (c *CompressCache) Flush(ctx context.Context) error {
+	if flushCache, ok := c.cache.(CacheFlushIf); ok {
+		return flushCache.Flush(ctx)
+	}
+	return nil
+}
+
+// Dump dump wrapped cache with decoded values, corrupt values are skipped,
+// wrapped cache must implement CacheDumpIf
+func (c *CompressCache) Dump(ctx context.Context, w io.Writer) (int, error) {
+	return dumpTransform(ctx, w, c.cache, func(rec *snapshot.Record) bool {
+		if rec.Kind != snapshot.KindBytes && rec.Kind != snapshot.KindString {
+			return false
+		}
+		val, err := c.decodeValue(rec.Value)
+		if err != nil {
+			return false
+		}
+		rec.Kind, rec.Value = snapshot.KindBytes, val
+		return true
+	})
+}
+
+// SetPinned encode and set pinned value, wrapped cache must implement CachePinIf
+func (c *CompressCache) SetPinned(ctx context.Context, strKey string, valIf interface{}) error {
+	pinCache, ok := c.cache.(CachePinIf)
+	if !ok {
+		return ErrNotSupport
+	}
+	val, err := c.encode(valIf)
+	if err != nil {
+		return err
+	}
+	return pinCache.SetPinned(ctx, strKey, val)
+}
+
+// AddPinned encode and add pinned value, return decoded value kept by cache,
+// wrapped cache must implement CachePinIf
+func (c *CompressCache) AddPinned(ctx context.Context, strKey string, valIf interface{}) (interface{}, error) {
+	pinCache, ok := c.cache.(CachePinIf)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	val, err := c.encode(valIf)
+	if err != nil {
+		return nil, err
+	}
+	keptIf, err := pinCache.AddPinned(ctx, strKey, val)
+	if err != nil {
+		return nil, err
+	}
+	return c.decode(keptIf)
+}
+
+// CompressStat compression counters
+func (c *CompressCache) CompressStat() CompressStats {
+	return CompressStats{
+		SetCnt:            atomic.LoadInt64(&c.stats.SetCnt),
+		CompressedCnt:     atomic.LoadInt64(&c.stats.CompressedCnt),
+		RawBytes:          atomic.LoadInt64(&c.stats.RawBytes),
+		StoredBytes:       atomic.LoadInt64(&c.stats.StoredBytes),
+		DecodeErrCnt:      atomic.LoadInt64(&c.stats.DecodeErrCnt),
+		EncodeErrCnt:      atomic.LoadInt64(&c.stats.EncodeErrCnt),
+		IncompressibleCnt: atomic.LoadInt64(&c.stats.IncompressibleCnt),
+	}
+}
+
+// encode header byte + payload
+func (c *CompressCache) encode(valIf interface{}) ([]byte, error) {
+	var raw []byte
+	switch val := valIf.(type) {
+	case []byte:
+		raw = val
+	case string:
+		raw = []byte(val)
+	default:
+		return nil, fmt.Errorf("CompressCache only support []byte and string type")
+	}
+	atomic.AddInt64(&c.stats.SetCnt, 1)
+	atomic.AddInt64(&c.stats.RawBytes, int64(len(raw)))
+	if len(raw) >= c.threshold {
+		encoded, err := c.codec.Encode(raw)
+		switch {
+		case err != nil:
+			atomic.AddInt64(&c.stats.EncodeErrCnt, 1)
+		case len(encoded) >= len(raw):
+			atomic.AddInt64(&c.stats.IncompressibleCnt, 1)
+		default:
+			atomic.AddInt64(&c.stats.CompressedCnt, 1)
+			return c.withHeader(c.codec.ID(), encoded), nil
+		}
+	}
+	return c.withHeader(CodecRaw, raw), nil
+}
+
+func (c *CompressCache) withHeader(id byte, payload []byte) []byte {
+	val := make([]byte, 1+len(payload))
+	val[0] = id
+	copy(val[1:], payload)
+	atomic.AddInt64(&c.stats.StoredBytes, int64(len(val)))
+	return val
+}
+
+// decode decode value, count errors
+func (c *CompressCache) decode(valIf interface{}) ([]byte, error) {
+	raw, err := c.decodeValue(valIf)
+	if err != nil {
+		atomic.AddInt64(&c.stats.DecodeErrCnt, 1)
+	}
+	return raw, err
+}
+
+// decodeValue check header byte and decode payload
+func (c *CompressCache) decodeValue(valIf interface{}) ([]byte, error) {
+	var val []byte
+	switch v := valIf.(type) {
+	case []byte:
+		val = v
+	case string:
+		val = []byte(v)
+	}
+	if len(val) == 0 {
+		return nil, ErrCodec
+	}
+	if val[0] == CodecRaw {
+		// copy, caller may modify value held by wrapped cache
+		return append([]byte(nil), val[1:]...), nil
+	}
+	codec, ok := c.codecs[val[0]]
+	if !ok {
+		return nil, ErrCodec
+	}
+	raw, err := codec.Decode(val[1:])
+	if err != nil {
+		return nil, ErrCodec
+	}
+	return raw, nil
+}
@@ -0,0 +1,82 @@
+package icache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iglev/icache"
+	"github.com/iglev/icache/icachetest"
+)
+
+func TestLRUObjCacheConformance(t *testing.T) {
+	icachetest.RunCacheIfSuite(t, func(clock icache.Clock) icache.CacheIf {
+		return icache.NewLRUObjCacheWithOpts(icachetest.MinCapacity, icache.SetLRUClock(clock))
+	})
+}
+
+func TestLRUByteCacheConformance(t *testing.T) {
+	icachetest.RunCacheIfSuite(t, func(clock icache.Clock) icache.CacheIf {
+		return icache.NewLRUByteCacheWithOpts(icachetest.MinCapacity, icache.SetLRUClock(clock))
+	})
+}
+
+func TestLRUJanitorConformance(t *testing.T) {
+	icachetest.RunCacheIfSuite(t, func(clock icache.Clock) icache.CacheIf {
+		return icache.NewLRUObjCacheWithOpts(icachetest.MinCapacity, icache.SetLRUClock(clock), icache.SetLRUJanitor(10*time.Millisecond, 100))
+	})
+}
+
+func TestRingByteCacheConformance(t *testing.T) {
+	icachetest.RunCacheIfSuite(t, func(clock icache.Clock) icache.CacheIf {
+		return icache.NewRingByteCache(1<<20, icache.SetRingShards(4), icache.SetRingClock(clock))
+	})
+}
+
+func TestCompressCacheConformance(t *testing.T) {
+	icachetest.RunCacheIfSuite(t, func(clock icache.Clock) icache.CacheIf {
+		return icache.NewCompressCache(icache.NewLRUObjCacheWithOpts(icachetest.MinCapacity, icache.SetLRUClock(clock)), icache.NewFlateCodec(-1), 16)
+	})
+}
+
+func TestCompressCachePlainConformance(t *testing.T) {
+	// wrapped caches without optional interfaces
+	for name, wrap := range plainWrappers() {
+		wrap := wrap
+		t.Run(name, func(t *testing.T) {
+			icachetest.RunCacheIfSuite(t, func(clock icache.Clock) icache.CacheIf {
+				return icache.NewCompressCache(wrap(clock), icache.NewFlateCodec(-1), 16)
+			})
+		})
+	}
+}
+
+// plainWrappers caches implementing CacheIf only
+func plainWrappers() map[string]func(clock icache.Clock) icache.CacheIf {
+	return map[string]func(clock icache.Clock) icache.CacheIf{
+		"Plain": func(clock icache.Clock) icache.CacheIf {
+			return struct{ icache.CacheIf }{icache.NewLRUObjCacheWithOpts(icachetest.MinCapacity, icache.SetLRUClock(clock))}
+		},
+		"Fault": func(clock icache.Clock) icache.CacheIf {
+			return icachetest.NewFaultCache(icache.NewLRUObjCacheWithOpts(icachetest.MinCapacity, icache.SetLRUClock(clock)), icachetest.NewFaultInjector(1))
+		},
+	}
+}
+
+func TestEncryptCacheConformance(t *testing.T) {
+	keyRing, err := icache.NewKeyRing(1, make([]byte, 32))
+	if err != nil {
+		t.Fatalf("NewKeyRing err=%+v", err)
+	}
+	icachetest.RunCacheIfSuite(t, func(clock icache.Clock) icache.CacheIf {
+		return icache.NewEncryptCache(icache.NewLRUByteCacheWithOpts(icachetest.MinCapacity, icache.SetLRUClock(clock)), keyRing)
+	})
+	// wrapped caches without optional interfaces
+	for name, wrap := range plainWrappers() {
+		wrap := wrap
+		t.Run(name, func(t *testing.T) {
+			icachetest.RunCacheIfSuite(t, func(clock icache.Clock) icache.CacheIf {
+				return icache.NewEncryptCache(wrap(clock), keyRing)
+			})
+		})
+	}
+}
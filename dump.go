@@ -0,0 +1,133 @@
+package icache
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/iglev/icache/snapshot"
+)
+
+// CacheDumpIf optional cache interface, dump entries in snapshot format
+type CacheDumpIf interface {
+	Dump(context.Context, io.Writer) (int, error)
+}
+
+// Dump dump cache entries of this ICache in snapshot format, return entry cnt,
+// CacheIf must implement CacheDumpIf. With namespace only entries of current
+// generation are dumped with user keys, namespace gen keys are always skipped
+func (ic *ICache) Dump(ctx context.Context, w io.Writer) (int, error) {
+	if !ic.acquire() {
+		return 0, ErrClosed
+	}
+	defer ic.release()
+	if !cacheSupports(ic.cache, isDumpCache) {
+		return 0, ErrNotSupport
+	}
+	if ic.ns == nil {
+		return dumpTransform(ctx, w, ic.cache, func(rec *snapshot.Record) bool {
+			return !strings.HasPrefix(rec.Key, nsGenKeyPrefix)
+		})
+	}
+	strPrefix, err := ic.ns.cacheKey(ctx, ic.cache, "")
+	if err != nil {
+		return 0, err
+	}
+	return dumpTransform(ctx, w, ic.cache, func(rec *snapshot.Record) bool {
+		if !strings.HasPrefix(rec.Key, strPrefix) {
+			return false
+		}
+		rec.Key = rec.Key[len(strPrefix):]
+		return true
+	})
+}
+
+// dumpTransform dump cache and rewrite records by fn, record is skipped if fn return false
+func dumpTransform(ctx context.Context, w io.Writer, cache CacheIf, fn func(*snapshot.Record) bool) (int, error) {
+	dumpCache, ok := cache.(CacheDumpIf)
+	if !ok {
+		return 0, ErrNotSupport
+	}
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		_, err := dumpCache.Dump(ctx, pw)
+		pw.CloseWithError(err)
+	}()
+	sr, err := snapshot.NewReader(pr)
+	if err != nil {
+		return 0, err
+	}
+	sw, err := snapshot.NewWriter(w, sr.Version())
+	if err != nil {
+		return 0, err
+	}
+	cnt := 0
+	for {
+		rec, err := sr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cnt, err
+		}
+		if !fn(rec) {
+			continue
+		}
+		if err := sw.Write(rec); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+	return cnt, sw.Flush()
+}
+
+// Dump dump entries not expired in latest snapshot version,
+// values neither []byte nor string are encoded as json, skipped if fail
+func (c *lruBase) Dump(ctx context.Context, w io.Writer) (int, error) {
+	sw, err := snapshot.NewWriter(w, snapshot.LatestVersion)
+	if err != nil {
+		return 0, err
+	}
+	cnt := 0
+	now := c.opts.clock.Now().UnixNano()
+	for _, keyIf := range c.keys() {
+		if err := ctx.Err(); err != nil {
+			return cnt, err
+		}
+		strKey, ok := keyIf.(string)
+		if !ok {
+			continue
+		}
+		c.mu.Lock()
+		item, ok := c.peekItem(keyIf)
+		c.mu.Unlock()
+		if !ok || item.expired(now) {
+			continue
+		}
+		rec := &snapshot.Record{
+			Key:      strKey,
+			ExpireTs: item.expireTs,
+			Idle:     time.Duration(item.idle),
+		}
+		switch val := item.val.(type) {
+		case []byte:
+			rec.Kind, rec.Value = snapshot.KindBytes, val
+		case string:
+			rec.Kind, rec.Value = snapshot.KindString, []byte(val)
+		default:
+			b, err := json.Marshal(val)
+			if err != nil {
+				continue
+			}
+			rec.Kind, rec.Value = snapshot.KindJSON, b
+		}
+		if err := sw.Write(rec); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+	return cnt, sw.Flush()
+}
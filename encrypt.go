@@ -0,0 +1,389 @@
+package icache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	envelopeVersion = 1
+	// envelope header: version byte, key id uint32, gcm nonce
+	envelopeHeaderSize = 1 + 4 + 12
+)
+
+var (
+	// ErrDecrypt value tampered, encrypted by unknown key or not an envelope,
+	// EncryptCache.IsErrNotFound reports it as miss
+	ErrDecrypt = fmt.Errorf("err decrypt")
+	// ErrNoKey key id not found in KeyProvider
+	ErrNoKey = fmt.Errorf("err no key")
+)
+
+// KeyProvider AES key provider, new values are encrypted by the current key,
+// retired keys stay readable until removed from the provider. Key of an id must never change.
+type KeyProvider interface {
+	CurrentKey() (uint32, []byte, error)
+	Key(keyID uint32) ([]byte, error)
+}
+
+// KeyRing in-memory KeyProvider supporting rotation
+type KeyRing struct {
+	mu      sync.RWMutex
+	keys    map[uint32][]byte
+	current uint32
+}
+
+// NewKeyRing new key ring with current key, key is 16, 24 or 32 bytes for AES-128, AES-192 or AES-256
+func NewKeyRing(keyID uint32, key []byte) (*KeyRing, error) {
+	kr := &KeyRing{keys: make(map[uint32][]byte)}
+	if err := kr.Add(keyID, key); err != nil {
+		return nil, err
+	}
+	kr.current = keyID
+	return kr, nil
+}
+
+// Add add key, existing key id can not be replaced
+func (kr *KeyRing) Add(keyID uint32, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, ok := kr.keys[keyID]; ok {
+		return fmt.Errorf("key id %d exists", keyID)
+	}
+	kr.keys[keyID] = append([]byte(nil), key...)
+	return nil
+}
+
+// Rotate set current key, the key must be added
+func (kr *KeyRing) Rotate(keyID uint32) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, ok := kr.keys[keyID]; !ok {
+		return ErrNoKey
+	}
+	kr.current = keyID
+	return nil
+}
+
+// Remove remove retired key, values encrypted by it become misses
+func (kr *KeyRing) Remove(keyID uint32) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if keyID == kr.current {
+		return fmt.Errorf("can not remove current key %d", keyID)
+	}
+	delete(kr.keys, keyID)
+	return nil
+}
+
+// CurrentKey current key
+func (kr *KeyRing) CurrentKey() (uint32, []byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.current, kr.keys[kr.current], nil
+}
+
+// Key key by id
+func (kr *KeyRing) Key(keyID uint32) ([]byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	key, ok := kr.keys[keyID]
+	if !ok {
+		return nil, ErrNoKey
+	}
+	return key, nil
+}
+
+// EncryptStats encryption counters
+type EncryptStats struct {
+	EncryptCnt    int64 // values encrypted
+	DecryptCnt    int64 // values decrypted
+	DecryptErrCnt int64 // tampered values, unknown keys and non envelope values
+}
+
+// EncryptCache CacheIf wrapper encrypting []byte and string values with AES-GCM, Get returns []byte.
+//
+// Stored value is an envelope: version byte, key id uint32, nonce, ciphertext with tag.
+// The cache key is authenticated, so values can not be moved between keys.
+// Optional interfaces are forwarded and work only if the wrapped cache implements them,
+// ICache checks the whole chain through CacheUnwrapIf, other callers should do the same.
+type EncryptCache struct {
+	cache    CacheIf
+	provider KeyProvider
+	aeads    sync.Map // key id -> cipher.AEAD
+
+	stats EncryptStats // atomic
+}
+
+// NewEncryptCache wrap byte-oriented cache with key provider
+func NewEncryptCache(cache CacheIf, provider KeyProvider) *EncryptCache {
+	return &EncryptCache{cache: cache, provider: provider}
+}
+
+// Get get, tampered value is a miss
+func (c *EncryptCache) Get(ctx context.Context, strKey string) (interface{}, error) {
+	valIf, err := c.cache.Get(ctx, strKey)
+	if err != nil {
+		return nil, err
+	}
+	return c.decrypt(strKey, valIf)
+}
+
+// Set set
+func (c *EncryptCache) Set(ctx context.Context, strKey string, valIf interface{}, iTTL int32) error {
+	val, err := c.encrypt(strKey, valIf)
+	if err != nil {
+		return err
+	}
+	return c.cache.Set(ctx, strKey, val, iTTL)
+}
+
+// SetWithExpire set with expire option, wrapped cache must implement CacheExpireIf
+func (c *EncryptCache) SetWithExpire(ctx context.Context, strKey string, valIf interface{}, opt ExpireOpt) error {
+	expireCache, ok := c.cache.(CacheExpireIf)
+	if !ok {
+		return ErrNotSupport
+	}
+	val, err := c.encrypt(strKey, valIf)
+	if err != nil {
+		return err
+	}
+	return expireCache.SetWithExpire(ctx, strKey, val, opt)
+}
+
+// Del del
+func (c *EncryptCache) Del(ctx context.Context, strKey string) error {
+	return c.cache.Del(ctx, strKey)
+}
+
+// IsErrNotFound is not found err, ErrDecrypt included
+func (c *EncryptCache) IsErrNotFound(err error) bool {
+	return err == ErrDecrypt || c.cache.IsErrNotFound(err)
+}
+
+// Peek peek and decrypt, wrapped cache must implement CachePeekIf
+func (c *EncryptCache) Peek(ctx context.Context, strKey string) (interface{}, time.Duration, error) {
+	peekCache, ok := c.cache.(CachePeekIf)
+	if !ok {
+		return nil, 0, ErrNotSupport
+	}
+	valIf, ttl, err := peekCache.Peek(ctx, strKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	val, err := c.decrypt(strKey, valIf)
+	return val, ttl, err
+}
+
+// Range range keys, wrapped cache must implement CacheIterIf
+func (c *EncryptCache) Range(ctx context.Context, fn func(string) bool) error {
+	iterCache, ok := c.cache.(CacheIterIf)
+	if !ok {
+		return ErrNotSupport
+	}
+	return iterCache.Range(ctx, fn)
+}
+
+// DelPrefix del keys with prefix, wrapped cache must implement CacheIterIf
+func (c *EncryptCache) DelPrefix(ctx context.Context, strPrefix string) (int, error) {
+	iterCache, ok := c.cache.(CacheIterIf)
+	if !ok {
+		return 0, ErrNotSupport
+	}
+	return iterCache.DelPrefix(ctx, strPrefix)
+}
+
+// Close close wrapped cache if it implements io.Closer
+func (c *EncryptCache) Close() error {
+	if closer, ok := c.cache.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Unwrap wrapped cache
+func (c *EncryptCache) Unwrap() CacheIf {
+	return c.cache
+}
+
+// AddEvictFunc add evict callback receiving decrypted values, nil if value can not be decrypted,
+// no-op if wrapped cache does not implement CacheEvictIf
+func (c *EncryptCache) AddEvictFunc(fn EvictFunc) {
+	evictCache, ok := c.cache.(CacheEvictIf)
+	if !ok {
+		return
+	}
+	evictCache.AddEvictFunc(func(strKey string, valIf interface{}, reason EvictReason) {
+		val, err := c.open(strKey, envelopeOf(valIf))
+		if err != nil {
+			val = nil
+		}
+		fn(strKey, val, reason)
+	})
+}
+
+// AddEvictCountFunc add evict counter to wrapped cache, keys are not changed by wrapper,
+// no-op if wrapped cache does not implement CacheEvictCountIf
+func (c *EncryptCache) AddEvictCountFunc(strPrefix string, fn EvictCountFunc) {
+	if countCache, ok := c.cache.(CacheEvictCountIf); ok {
+		countCache.AddEvictCountFunc(strPrefix, fn)
+	}
+}
+
+// Stat stats of wrapped cache, zero if it does not implement CacheStatIf
+func (c *EncryptCache) Stat() BackendStats {
+	if statCache, ok := c.cache.(CacheStatIf); ok {
+		return statCache.Stat()
+	}
+	return BackendStats{}
+}
+
+// Flush flush wrapped cache if it implements CacheFlushIf
+func (c *EncryptCache) Flush(ctx context.Context) error {
+	if flushCache, ok := c.cache.(CacheFlushIf); ok {
+		return flushCache.Flush(ctx)
+	}
+	return nil
+}
+
+// Dump dump wrapped cache, values stay encrypted, wrapped cache must implement CacheDumpIf
+func (c *EncryptCache) Dump(ctx context.Context, w io.Writer) (int, error) {
+	dumpCache, ok := c.cache.(CacheDumpIf)
+	if !ok {
+		return 0, ErrNotSupport
+	}
+	return dumpCache.Dump(ctx, w)
+}
+
+// SetPinned encrypt and set pinned value, wrapped cache must implement CachePinIf
+func (c *EncryptCache) SetPinned(ctx context.Context, strKey string, valIf interface{}) error {
+	pinCache, ok := c.cache.(CachePinIf)
+	if !ok {
+		return ErrNotSupport
+	}
+	val, err := c.encrypt(strKey, valIf)
+	if err != nil {
+		return err
+	}
+	return pinCache.SetPinned(ctx, strKey, val)
+}
+
+// AddPinned encrypt and add pinned value, return decrypted value kept by cache,
+// wrapped cache must implement CachePinIf
+func (c *EncryptCache) AddPinned(ctx context.Context, strKey string, valIf interface{}) (interface{}, error) {
+	pinCache, ok := c.cache.(CachePinIf)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	val, err := c.encrypt(strKey, valIf)
+	if err != nil {
+		return nil, err
+	}
+	keptIf, err := pinCache.AddPinned(ctx, strKey, val)
+	if err != nil {
+		return nil, err
+	}
+	return c.decrypt(strKey, keptIf)
+}
+
+// EncryptStat encryption counters
+func (c *EncryptCache) EncryptStat() EncryptStats {
+	return EncryptStats{
+		EncryptCnt:    atomic.LoadInt64(&c.stats.EncryptCnt),
+		DecryptCnt:    atomic.LoadInt64(&c.stats.DecryptCnt),
+		DecryptErrCnt: atomic.LoadInt64(&c.stats.DecryptErrCnt),
+	}
+}
+
+// aead aead of key id, cached
+func (c *EncryptCache) aead(keyID uint32, key []byte) (cipher.AEAD, error) {
+	if aeadIf, ok := c.aeads.Load(keyID); ok {
+		return aeadIf.(cipher.AEAD), nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.aeads.Store(keyID, aead)
+	return aead, nil
+}
+
+func (c *EncryptCache) encrypt(strKey string, valIf interface{}) ([]byte, error) {
+	var plain []byte
+	switch val := valIf.(type) {
+	case []byte:
+		plain = val
+	case string:
+		plain = []byte(val)
+	default:
+		return nil, fmt.Errorf("EncryptCache only support []byte and string type")
+	}
+	keyID, key, err := c.provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := c.aead(keyID, key)
+	if err != nil {
+		return nil, err
+	}
+	envelope := make([]byte, envelopeHeaderSize, envelopeHeaderSize+len(plain)+aead.Overhead())
+	envelope[0] = envelopeVersion
+	binary.BigEndian.PutUint32(envelope[1:], keyID)
+	nonce := envelope[5:envelopeHeaderSize]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&c.stats.EncryptCnt, 1)
+	return aead.Seal(envelope, nonce, plain, []byte(strKey)), nil
+}
+
+func (c *EncryptCache) decrypt(strKey string, valIf interface{}) ([]byte, error) {
+	plain, err := c.open(strKey, envelopeOf(valIf))
+	if err != nil {
+		atomic.AddInt64(&c.stats.DecryptErrCnt, 1)
+		return nil, ErrDecrypt
+	}
+	atomic.AddInt64(&c.stats.DecryptCnt, 1)
+	return plain, nil
+}
+
+func envelopeOf(valIf interface{}) []byte {
+	switch val := valIf.(type) {
+	case []byte:
+		return val
+	case string:
+		return []byte(val)
+	}
+	return nil
+}
+
+func (c *EncryptCache) open(strKey string, envelope []byte) ([]byte, error) {
+	if len(envelope) < envelopeHeaderSize || envelope[0] != envelopeVersion {
+		return nil, ErrDecrypt
+	}
+	keyID := binary.BigEndian.Uint32(envelope[1:])
+	key, err := c.provider.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := c.aead(keyID, key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, envelope[5:envelopeHeaderSize], envelope[envelopeHeaderSize:], []byte(strKey))
+}
@@ -0,0 +1,49 @@
+package icache
+
+// EvictReason evict reason
+type EvictReason int
+
+const (
+	// EvictCapacity evicted by capacity
+	EvictCapacity EvictReason = iota
+	// EvictExpired evicted by ttl or idle timeout
+	EvictExpired
+	// EvictDeleted deleted by user
+	EvictDeleted
+	// EvictReplaced replaced by new value of the same key
+	EvictReplaced
+)
+
+// String reason name
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictDeleted:
+		return "deleted"
+	case EvictReplaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+// EvictFunc evict callback, val is the value set by user
+type EvictFunc func(strKey string, valIf interface{}, reason EvictReason)
+
+// CacheEvictIf optional cache interface, support evict listener
+type CacheEvictIf interface {
+	AddEvictFunc(EvictFunc)
+}
+
+// EvictCountFunc evict counter callback, called with cache lock held, must not call the cache
+type EvictCountFunc func(reason EvictReason)
+
+// CacheEvictCountIf optional cache interface, count evictions of keys with prefix
+// without copying keys and values out of cache, ICache counts evictions through it
+// if no EvictFunc is set
+type CacheEvictCountIf interface {
+	AddEvictCountFunc(strPrefix string, fn EvictCountFunc)
+}
@@ -0,0 +1,164 @@
+package icache_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/iglev/icache"
+	"github.com/iglev/icache/icachetest"
+)
+
+func sourceGetter(ctx context.Context, strKey string, dest icache.SinkIf) error {
+	return dest.SetString("val:" + strKey)
+}
+
+func TestFaultInjectorDeterministic(t *testing.T) {
+	run := func() []bool {
+		fi := icachetest.NewFaultInjector(42, icachetest.Fault{ErrRate: 0.3})
+		cache := icachetest.NewFaultCache(icache.NewLRUObjCache(10), fi)
+		var fails []bool
+		for i := 0; i < 100; i++ {
+			_, err := cache.Get(context.Background(), "k")
+			fails = append(fails, err == icachetest.ErrInjected)
+		}
+		return fails
+	}
+	a, b := run(), run()
+	if fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Fatalf("same seed should inject same faults")
+	}
+	cnt := 0
+	for _, fail := range a {
+		if fail {
+			cnt++
+		}
+	}
+	if cnt < 15 || cnt > 45 {
+		t.Fatalf("injected cnt=%d with rate 0.3", cnt)
+	}
+}
+
+func TestFaultCacheErrors(t *testing.T) {
+	ctx := context.Background()
+	fi := icachetest.NewFaultInjector(1,
+		icachetest.Fault{Pattern: "bad:*", Ops: icachetest.FaultGet, ErrRate: 1},
+	)
+	ic, err := icache.NewICache(
+		icache.SetCache(icachetest.NewFaultCache(icache.NewLRUObjCache(100), fi)),
+		icache.SetGetter(icache.GetterIfFunc(sourceGetter)),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	var val string
+	for i := 0; i < 3; i++ {
+		// cache get fails, load from source
+		if err := ic.Get(ctx, "bad:1", icache.StringSink(&val)); err != nil || val != "val:bad:1" {
+			t.Fatalf("Get val=%s err=%+v", val, err)
+		}
+		if err := ic.Get(ctx, "good:1", icache.StringSink(&val)); err != nil || val != "val:good:1" {
+			t.Fatalf("Get val=%s err=%+v", val, err)
+		}
+	}
+	stat := ic.GetStat()
+	// get and recheck in flight group both fail for bad key
+	if stat.ErrCnt != 6 || stat.SourceCnt != 4 || stat.HitCnt != 2 {
+		t.Fatalf("stat=%+v", stat)
+	}
+	if fs := fi.Stats(); fs.Errors != 6 {
+		t.Fatalf("fault stats=%+v", fs)
+	}
+
+	// backend recovers
+	fi.SetFaults()
+	ic.Get(ctx, "bad:1", icache.StringSink(&val))
+	if stat := ic.GetStat(); stat.HitCnt != 3 {
+		t.Fatalf("stat=%+v", stat)
+	}
+}
+
+func TestFaultGetterErrors(t *testing.T) {
+	ctx := context.Background()
+	fi := icachetest.NewFaultInjector(1,
+		icachetest.Fault{Pattern: "db:*", ErrRate: 1},
+		icachetest.Fault{Pattern: "slow:*", Latency: 50 * time.Millisecond, Timeout: 10 * time.Millisecond},
+	)
+	ic, _ := icache.NewICache(
+		icache.SetCache(icache.NewLRUObjCache(100)),
+		icache.SetGetter(icachetest.NewFaultGetter(icache.GetterIfFunc(sourceGetter), fi)),
+	)
+	var val string
+	if err := ic.Get(ctx, "db:1", icache.StringSink(&val)); err != icachetest.ErrInjected {
+		t.Fatalf("Get err=%+v", err)
+	}
+	startTime := time.Now()
+	if err := ic.Get(ctx, "slow:1", icache.StringSink(&val)); err != icachetest.ErrInjectedTimeout {
+		t.Fatalf("Get err=%+v", err)
+	}
+	if cost := time.Since(startTime); cost < 10*time.Millisecond || cost >= 50*time.Millisecond {
+		t.Fatalf("timeout cost=%v", cost)
+	}
+	if err := ic.Get(ctx, "ok", icache.StringSink(&val)); err != nil {
+		t.Fatalf("Get err=%+v", err)
+	}
+	stat := ic.GetStat()
+	if stat.SourceCnt != 3 || stat.SourceErrCnt != 2 || stat.SourceHitCnt != 1 {
+		t.Fatalf("stat=%+v", stat)
+	}
+	if fs := fi.Stats(); fs.Calls != 3 || fs.Errors != 2 || fs.Timeouts != 1 || fs.Delayed != 1 {
+		t.Fatalf("fault stats=%+v", fs)
+	}
+}
+
+func TestFaultGetterHang(t *testing.T) {
+	fi := icachetest.NewFaultInjector(1, icachetest.Fault{HangRate: 1})
+	ic, _ := icache.NewICache(
+		icache.SetCache(icache.NewLRUObjCache(100)),
+		icache.SetGetter(icachetest.NewFaultGetter(icache.GetterIfFunc(sourceGetter), fi)),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var val string
+	if err := ic.Get(ctx, "k", icache.StringSink(&val)); err != context.DeadlineExceeded {
+		t.Fatalf("Get err=%+v", err)
+	}
+	if stat := ic.GetStat(); stat.SourceErrCnt != 1 {
+		t.Fatalf("stat=%+v", stat)
+	}
+}
+
+func TestFaultRateLimit(t *testing.T) {
+	ctx := context.Background()
+	// every source load fails, misses keep hitting the source until rate limited
+	fi := icachetest.NewFaultInjector(1, icachetest.Fault{ErrRate: 1})
+	ic, _ := icache.NewICache(
+		icache.SetCache(icache.NewLRUObjCache(100)),
+		icache.SetGetter(icachetest.NewFaultGetter(icache.GetterIfFunc(sourceGetter), fi)),
+		icache.SetRateLimit(5),
+	)
+	var val string
+	limited := 0
+	for i := 0; i < 10; i++ {
+		err := ic.Get(ctx, "k", icache.StringSink(&val))
+		if err == icache.ErrRateLimit {
+			limited++
+		} else if err != icachetest.ErrInjected {
+			t.Fatalf("Get err=%+v", err)
+		}
+	}
+	if limited != 5 || fi.Stats().Calls != 5 {
+		t.Fatalf("limited=%d fault stats=%+v", limited, fi.Stats())
+	}
+	if stat := ic.GetStat(); stat.SourceErrCnt != 10 {
+		t.Fatalf("stat=%+v", stat)
+	}
+}
+
+func TestFaultCacheConformance(t *testing.T) {
+	// no faults, wrapper must keep CacheIf behavior
+	icachetest.RunCacheIfSuite(t, func(clock icache.Clock) icache.CacheIf {
+		return icachetest.NewFaultCache(icache.NewLRUObjCacheWithOpts(icachetest.MinCapacity, icache.SetLRUClock(clock)), icachetest.NewFaultInjector(1))
+	})
+}
@@ -0,0 +1,119 @@
+package icache
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	histSubBits    = 3 // 8 linear sub buckets per power of 2, relative error < 12.5%
+	histSubBuckets = 1 << histSubBits
+	histBuckets    = (64 - histSubBits + 1) * histSubBuckets
+)
+
+// Histogram lock-free log-linear latency histogram
+type Histogram struct {
+	counts [histBuckets]int64
+	count  int64
+	sum    int64 // nano
+}
+
+// Record record latency
+func (h *Histogram) Record(d time.Duration) {
+	if h == nil {
+		return
+	}
+	if d < 0 {
+		d = 0
+	}
+	atomic.AddInt64(&h.counts[histIndex(uint64(d))], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// Count record cnt
+func (h *Histogram) Count() int64 {
+	if h == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&h.count)
+}
+
+// Sum sum of latency
+func (h *Histogram) Sum() time.Duration {
+	if h == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&h.sum))
+}
+
+// Mean mean latency
+func (h *Histogram) Mean() time.Duration {
+	cnt := h.Count()
+	if cnt == 0 {
+		return 0
+	}
+	return h.Sum() / time.Duration(cnt)
+}
+
+// Percentile latency at fPercent in [0, 100], upper bound of the bucket
+func (h *Histogram) Percentile(fPercent float64) time.Duration {
+	if h == nil {
+		return 0
+	}
+	var counts [histBuckets]int64
+	var total int64
+	for i := range h.counts {
+		counts[i] = atomic.LoadInt64(&h.counts[i])
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := int64(fPercent / 100 * float64(total))
+	if rank < 1 {
+		rank = 1
+	}
+	var cum int64
+	for i, cnt := range counts {
+		cum += cnt
+		if cum >= rank {
+			return time.Duration(histUpper(i))
+		}
+	}
+	return time.Duration(histUpper(histBuckets - 1))
+}
+
+// Reset reset histogram
+func (h *Histogram) Reset() {
+	if h == nil {
+		return
+	}
+	for i := range h.counts {
+		atomic.StoreInt64(&h.counts[i], 0)
+	}
+	atomic.StoreInt64(&h.count, 0)
+	atomic.StoreInt64(&h.sum, 0)
+}
+
+// histIndex bucket index of v
+func histIndex(v uint64) int {
+	if v < histSubBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - 1
+	sub := (v >> uint(exp-histSubBits)) & (histSubBuckets - 1)
+	return (exp-histSubBits+1)*histSubBuckets + int(sub)
+}
+
+// histUpper exclusive upper bound of bucket idx
+func histUpper(idx int) uint64 {
+	if idx < histSubBuckets {
+		return uint64(idx) + 1
+	}
+	exp := idx/histSubBuckets + histSubBits - 1
+	sub := uint64(idx % histSubBuckets)
+	lower := (histSubBuckets + sub) << uint(exp-histSubBits)
+	return lower + 1<<uint(exp-histSubBits)
+}
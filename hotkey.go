@@ -0,0 +1,203 @@
+package icache
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HotKey hot key
+type HotKey struct {
+	Key   string
+	Count int64 // estimated count, may be over estimated by at most Err
+	Err   int64 // over estimation bound
+}
+
+const (
+	hotKeyShards           = 16
+	minHotKeyShardCapacity = 16
+)
+
+// hotKeyTracker top-K heavy hitters in sliding time window, keys are sharded by hash,
+// each shard keeps space-saving summary of the current and previous window
+type hotKeyTracker struct {
+	window time.Duration
+	clock  Clock
+	shards []*hotKeyShard
+}
+
+// hotKeyShard counters of one shard, windows are aligned across shards
+type hotKeyShard struct {
+	capacity int
+
+	mu        sync.Mutex
+	cur       *spaceSaving
+	prev      *spaceSaving
+	windowIdx int64 // current window index
+}
+
+func newHotKeyTracker(iCapacity int, window time.Duration) *hotKeyTracker {
+	// small capacity uses fewer shards, every shard keeps enough counters
+	iShards := iCapacity / minHotKeyShardCapacity
+	if iShards > hotKeyShards {
+		iShards = hotKeyShards
+	}
+	if iShards < 1 {
+		iShards = 1
+	}
+	h := &hotKeyTracker{
+		window: window,
+		clock:  realClock{},
+		shards: make([]*hotKeyShard, iShards),
+	}
+	iShardCapacity := (iCapacity + iShards - 1) / iShards
+	for i := range h.shards {
+		h.shards[i] = &hotKeyShard{
+			capacity: iShardCapacity,
+			cur:      newSpaceSaving(iShardCapacity),
+			prev:     newSpaceSaving(iShardCapacity),
+		}
+	}
+	return h
+}
+
+// Add add key access
+func (h *hotKeyTracker) Add(strKey string) {
+	s := h.shards[ringHash(strKey)%uint64(len(h.shards))]
+	idx := h.windowIdx()
+	s.mu.Lock()
+	s.rotate(idx)
+	s.cur.offer(strKey)
+	s.mu.Unlock()
+}
+
+// Top top n keys in the last one to two windows
+func (h *hotKeyTracker) Top(n int) []HotKey {
+	idx := h.windowIdx()
+	var hotKeys []HotKey
+	for _, s := range h.shards {
+		s.mu.Lock()
+		s.rotate(idx)
+		// a key is only in its own shard, merge current and previous window
+		merged := make(map[string]HotKey, len(s.cur.entries)+len(s.prev.entries))
+		for _, ss := range []*spaceSaving{s.prev, s.cur} {
+			for _, e := range ss.entries {
+				hk := merged[e.key]
+				hk.Key = e.key
+				hk.Count += e.count
+				hk.Err += e.err
+				merged[e.key] = hk
+			}
+		}
+		s.mu.Unlock()
+		for _, hk := range merged {
+			hotKeys = append(hotKeys, hk)
+		}
+	}
+
+	sort.Slice(hotKeys, func(i, j int) bool {
+		if hotKeys[i].Count != hotKeys[j].Count {
+			return hotKeys[i].Count > hotKeys[j].Count
+		}
+		return hotKeys[i].Key < hotKeys[j].Key
+	})
+	if n > 0 && len(hotKeys) > n {
+		hotKeys = hotKeys[:n]
+	}
+	return hotKeys
+}
+
+// windowIdx index of window now is in, 0 if no window
+func (h *hotKeyTracker) windowIdx() int64 {
+	if h.window <= 0 {
+		return 0
+	}
+	return h.clock.Now().UnixNano() / int64(h.window)
+}
+
+// rotate rotate window to idx, lock held
+func (s *hotKeyShard) rotate(idx int64) {
+	if idx <= s.windowIdx {
+		return
+	}
+	if idx == s.windowIdx+1 {
+		s.prev = s.cur
+	} else {
+		// idle for more than one window
+		s.prev = newSpaceSaving(s.capacity)
+	}
+	s.cur = newSpaceSaving(s.capacity)
+	s.windowIdx = idx
+}
+
+// spaceSaving space-saving counters with min-heap
+type spaceSaving struct {
+	capacity int
+	entries  ssHeap
+	index    map[string]*ssEntry
+}
+
+type ssEntry struct {
+	key   string
+	count int64
+	err   int64
+	pos   int // index in heap
+}
+
+func newSpaceSaving(iCapacity int) *spaceSaving {
+	return &spaceSaving{
+		capacity: iCapacity,
+		entries:  make(ssHeap, 0, iCapacity),
+		index:    make(map[string]*ssEntry, iCapacity),
+	}
+}
+
+func (s *spaceSaving) offer(strKey string) {
+	if e, ok := s.index[strKey]; ok {
+		e.count++
+		heap.Fix(&s.entries, e.pos)
+		return
+	}
+	if len(s.entries) < s.capacity {
+		e := &ssEntry{key: strKey, count: 1}
+		s.index[strKey] = e
+		heap.Push(&s.entries, e)
+		return
+	}
+	if len(s.entries) == 0 {
+		return
+	}
+	// replace min counter
+	e := s.entries[0]
+	delete(s.index, e.key)
+	e.key = strKey
+	e.err = e.count
+	e.count++
+	s.index[strKey] = e
+	heap.Fix(&s.entries, 0)
+}
+
+// ssHeap min-heap by count
+type ssHeap []*ssEntry
+
+func (h ssHeap) Len() int           { return len(h) }
+func (h ssHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *ssHeap) Push(x interface{}) {
+	e := x.(*ssEntry)
+	e.pos = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *ssHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
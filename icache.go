@@ -3,6 +3,9 @@ package icache
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"sync"
+	"time"
 
 	"github.com/iglev/icache/singleflight"
 	"github.com/juju/ratelimit"
@@ -13,15 +16,33 @@ type ICache struct {
 	cache       CacheIf
 	getter      GetterIf
 	flightGroup FlightGroupIf
+	ns          *namespace
+	ttlJitter   float64
+	idleTTL     time.Duration
+	evictFuncs  []EvictFunc
+	hotKeys     *hotKeyTracker
+	hotSources  *hotKeyTracker
+	tracer      Tracer
+	clock       Clock
+
+	interceptors []Interceptor
 
 	stats       Stats
 	rateLimiter *ratelimit.Bucket
+
+	lifeMu     sync.RWMutex
+	closed     bool
+	drained    chan struct{} // closed when in-flight ops finish after Close
+	inflight   sync.WaitGroup
+	closeCache bool
+	finishOnce sync.Once
+	closeErr   error // flush and close result
 }
 
 // NewICache new ICache
 func NewICache(opts ...Option) (*ICache, error) {
 	ic := &ICache{
-		stats: Stats{},
+		stats: Stats{Latency: newLatencyStats()},
 	}
 
 	// do opt
@@ -38,36 +59,101 @@ func NewICache(opts ...Option) (*ICache, error) {
 	if ic.getter == nil {
 		return nil, ErrGetterIf
 	}
+	if ic.idleTTL > 0 && !cacheSupports(ic.cache, isExpireCache) {
+		return nil, ErrNotSupport
+	}
 	if ic.flightGroup == nil {
 		ic.flightGroup = &singleflight.Group{}
 	}
+	if ic.tracer == nil {
+		ic.tracer = noopTracer{}
+	}
+	if ic.clock == nil {
+		ic.clock = realClock{}
+	}
+	if ic.ns != nil {
+		ic.ns.clock = ic.clock
+	}
+	if ic.hotKeys != nil {
+		ic.hotKeys.clock = ic.clock
+		ic.hotSources.clock = ic.clock
+	}
+	switch {
+	case len(ic.evictFuncs) == 0 && cacheSupports(ic.cache, isEvictCountCache):
+		// count only, keys and values are not copied out of cache
+		strPrefix := ""
+		if ic.ns != nil {
+			strPrefix = ic.ns.name + ":"
+		}
+		ic.cache.(CacheEvictCountIf).AddEvictCountFunc(strPrefix, ic.onEvictCount)
+	case cacheSupports(ic.cache, isEvictCache):
+		ic.cache.(CacheEvictIf).AddEvictFunc(ic.onEvict)
+	case len(ic.evictFuncs) > 0:
+		return nil, ErrNotSupport
+	}
 
 	return ic, nil
 }
 
 // Get get key
 func (ic *ICache) Get(ctx context.Context, strKey string, dest SinkIf) error {
-	ic.stats.AddGet(1)
+	if !ic.acquire() {
+		return ErrClosed
+	}
+	defer ic.release()
+	// count before interceptors, Get short-circuited by interceptor is counted too
+	st := &opStat{get: 1}
+	defer ic.stats.commit(st)
+	return ic.intercept(ctx, &OpInfo{Op: OpGet, Key: strKey}, func(ctx context.Context, info *OpInfo) error {
+		return ic.get(ctx, strKey, dest, info, st)
+	})
+}
+
+// get get key
+func (ic *ICache) get(ctx context.Context, strKey string, dest SinkIf, info *OpInfo, st *opStat) (err error) {
+	ctx, span := ic.tracer.Start(ctx, SpanGet)
+	span.SetAttr(AttrKey, strKey)
+	defer func() {
+		span.End(err)
+	}()
+	if ic.hotKeys != nil {
+		ic.hotKeys.Add(strKey)
+	}
 	if dest == nil {
-		ic.stats.AddErr(1)
+		st.err++
 		return fmt.Errorf("nil dest")
 	}
-	view, err := ic.loadCache(ctx, strKey)
+	strCacheKey, err := ic.cacheKey(ctx, strKey)
+	if err != nil {
+		st.err++
+		return err
+	}
+	view, err := ic.loadCache(ctx, strCacheKey)
 	if err != nil {
 		if !ic.cache.IsErrNotFound(err) {
-			ic.stats.AddErr(1)
+			st.err++
 			// loadCache fail, whatever
 			// go on ic.load
 		}
 	} else {
 		// hit cache
-		ic.stats.AddHit(1)
+		st.hit = 1
+		span.SetAttr(AttrHit, true)
+		info.Hit = true
 		return dest.SetView(view)
 	}
 
 	// miss
+	span.SetAttr(AttrHit, false)
 	bDestSetView := false
-	view, bDestSetView, err = ic.load(ctx, strKey, dest)
+	loadInfo := &OpInfo{Op: OpLoad, Key: strKey}
+	err = ic.intercept(ctx, loadInfo, func(ctx context.Context, loadInfo *OpInfo) error {
+		var loadErr error
+		view, bDestSetView, loadErr = ic.load(ctx, strKey, strCacheKey, dest, loadInfo, st)
+		return loadErr
+	})
+	info.Shared = loadInfo.Shared
+	info.SourceLatency = loadInfo.SourceLatency
 	if err != nil {
 		return err
 	}
@@ -79,28 +165,243 @@ func (ic *ICache) Get(ctx context.Context, strKey string, dest SinkIf) error {
 
 // Delete del key
 func (ic *ICache) Delete(ctx context.Context, strKey string) error {
+	if !ic.acquire() {
+		return ErrClosed
+	}
+	defer ic.release()
+	return ic.intercept(ctx, &OpInfo{Op: OpDelete, Key: strKey}, func(ctx context.Context, info *OpInfo) error {
+		return ic.del(ctx, strKey)
+	})
+}
+
+// del del key
+func (ic *ICache) del(ctx context.Context, strKey string) error {
 	ic.stats.AddDel(1)
-	err := ic.cache.Del(ctx, strKey)
+	strCacheKey, err := ic.cacheKey(ctx, strKey)
+	if err != nil {
+		ic.stats.AddErr(1)
+		return err
+	}
+	err = ic.cache.Del(ctx, strCacheKey)
+	if err != nil {
+		ic.stats.AddErr(1)
+		return err
+	}
+	return nil
+}
+
+// DeletePrefix del keys with prefix, CacheIf must implement CacheIterIf
+func (ic *ICache) DeletePrefix(ctx context.Context, strPrefix string) (int, error) {
+	if !ic.acquire() {
+		return 0, ErrClosed
+	}
+	defer ic.release()
+	cnt := 0
+	err := ic.intercept(ctx, &OpInfo{Op: OpDeletePrefix, Key: strPrefix}, func(ctx context.Context, info *OpInfo) error {
+		var err error
+		cnt, err = ic.delPrefix(ctx, strPrefix)
+		return err
+	})
+	return cnt, err
+}
+
+// delPrefix del keys with prefix
+func (ic *ICache) delPrefix(ctx context.Context, strPrefix string) (int, error) {
+	ic.stats.AddDel(1)
+	if !cacheSupports(ic.cache, isIterCache) {
+		ic.stats.AddErr(1)
+		return 0, ErrNotSupport
+	}
+	strCachePrefix, err := ic.cacheKey(ctx, strPrefix)
+	if err != nil {
+		ic.stats.AddErr(1)
+		return 0, err
+	}
+	cnt, err := ic.cache.(CacheIterIf).DelPrefix(ctx, strCachePrefix)
+	if err != nil {
+		ic.stats.AddErr(1)
+		return cnt, err
+	}
+	return cnt, nil
+}
+
+// Peek get value and remaining ttl in cache without loading source,
+// ttl > 0 remaining ttl, ttl == 0 never expire, ttl < 0 unknown as CacheIf chain not implements CachePeekIf
+func (ic *ICache) Peek(ctx context.Context, strKey string) (interface{}, time.Duration, error) {
+	if !ic.acquire() {
+		return nil, 0, ErrClosed
+	}
+	defer ic.release()
+	strCacheKey, err := ic.cacheKey(ctx, strKey)
 	if err != nil {
+		return nil, 0, err
+	}
+	if cacheSupports(ic.cache, isPeekCache) {
+		return ic.cache.(CachePeekIf).Peek(ctx, strCacheKey)
+	}
+	valIf, err := ic.cache.Get(ctx, strCacheKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	return valIf, -1, nil
+}
+
+// Purge remove all keys, bump generation if namespace set,
+// otherwise CacheIf must implement CacheIterIf
+func (ic *ICache) Purge(ctx context.Context) error {
+	if ic.ns != nil {
+		return ic.BumpNamespace(ctx)
+	}
+	_, err := ic.DeletePrefix(ctx, "")
+	return err
+}
+
+// BumpNamespace invalidate all keys in namespace, need SetNamespace option
+func (ic *ICache) BumpNamespace(ctx context.Context) error {
+	if !ic.acquire() {
+		return ErrClosed
+	}
+	defer ic.release()
+	info := &OpInfo{Op: OpBumpNamespace}
+	if ic.ns != nil {
+		info.Key = ic.ns.name
+	}
+	return ic.intercept(ctx, info, func(ctx context.Context, info *OpInfo) error {
+		return ic.bumpNamespace(ctx)
+	})
+}
+
+// bumpNamespace bump namespace generation
+func (ic *ICache) bumpNamespace(ctx context.Context) error {
+	if ic.ns == nil {
+		return ErrNamespace
+	}
+	if _, err := ic.ns.bump(ctx, ic.cache); err != nil {
 		ic.stats.AddErr(1)
 		return err
 	}
 	return nil
 }
 
-// GetStat get stat
+// HotKeys top n most accessed keys, need SetHotKeys option
+func (ic *ICache) HotKeys(n int) []HotKey {
+	if ic.hotKeys == nil {
+		return nil
+	}
+	return ic.hotKeys.Top(n)
+}
+
+// HotSourceKeys top n keys causing most source loads, need SetHotKeys option
+func (ic *ICache) HotSourceKeys(n int) []HotKey {
+	if ic.hotSources == nil {
+		return nil
+	}
+	return ic.hotSources.Top(n)
+}
+
+// GetStat get stat, counters are loaded atomically
 func (ic *ICache) GetStat() Stats {
-	return ic.stats
+	return ic.stats.load()
+}
+
+// Snapshot get stats snapshot, merged with backend stats if CacheIf implements CacheStatIf
+// and compression stats if CacheIf or a cache it wraps implements CacheCompressStatIf
+func (ic *ICache) Snapshot() StatsSnapshot {
+	snap := ic.stats.Snapshot()
+	snap.Time = ic.clock.Now()
+	if cacheSupports(ic.cache, isStatCache) {
+		snap.HasBackend = true
+		snap.Backend = ic.cache.(CacheStatIf).Stat()
+	}
+	for cache := ic.cache; cache != nil; {
+		if compressCache, ok := cache.(CacheCompressStatIf); ok {
+			snap.HasCompress = true
+			snap.Compress = compressCache.CompressStat()
+			break
+		}
+		wrapper, ok := cache.(CacheUnwrapIf)
+		if !ok {
+			break
+		}
+		cache = wrapper.Unwrap()
+	}
+	return snap
+}
+
+// ResetStat reset stats
+func (ic *ICache) ResetStat() {
+	ic.stats.Reset()
+}
+
+// onEvict CacheIf evict callback, count stats and notify user with user key
+func (ic *ICache) onEvict(strCacheKey string, valIf interface{}, reason EvictReason) {
+	strKey := strCacheKey
+	if ic.ns != nil {
+		var ok bool
+		if strKey, ok = ic.ns.userKey(strCacheKey); !ok {
+			// not key of this namespace
+			return
+		}
+	}
+	ic.stats.AddEvict(reason, 1)
+	for _, fn := range ic.evictFuncs {
+		fn(strKey, valIf, reason)
+	}
+}
+
+// onEvictCount CacheIf evict counter callback
+func (ic *ICache) onEvictCount(reason EvictReason) {
+	ic.stats.AddEvict(reason, 1)
+}
+
+// cacheKey key in CacheIf
+func (ic *ICache) cacheKey(ctx context.Context, strKey string) (string, error) {
+	if ic.ns == nil {
+		return strKey, nil
+	}
+	return ic.ns.cacheKey(ctx, ic.cache, strKey)
 }
 
 func (ic *ICache) setCache(ctx context.Context, strKey string, view View) error {
-	return ic.cache.Set(ctx, strKey, view.v, view.ttl)
+	startTime := time.Now()
+	defer func() {
+		ic.stats.Latency.CacheSet.Record(time.Since(startTime))
+	}()
+	ttl := ic.jitterTTL(view.ttl)
+	if cacheSupports(ic.cache, isExpireCache) {
+		idle := view.idle
+		if idle <= 0 {
+			idle = ic.idleTTL
+		}
+		return ic.cache.(CacheExpireIf).SetWithExpire(ctx, strKey, view.v, ExpireOpt{TTL: ttl, Idle: idle})
+	}
+	if view.idle > 0 {
+		// caching without idle timeout would keep the value too long
+		return ErrNotSupport
+	}
+	// CacheIf only support ttl in seconds, round up
+	iTTL := int32((ttl + time.Second - 1) / time.Second)
+	return ic.cache.Set(ctx, strKey, view.v, iTTL)
+}
+
+// jitterTTL add random jitter in [0, ttl*ttlJitter)
+func (ic *ICache) jitterTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 || ic.ttlJitter <= 0 {
+		return ttl
+	}
+	maxJitter := int64(float64(ttl) * ic.ttlJitter)
+	if maxJitter <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(rand.Int63n(maxJitter))
 }
 
 // load cache
 func (ic *ICache) loadCache(ctx context.Context, strKey string) (View, error) {
 	var view View
+	startTime := time.Now()
 	valIf, err := ic.cache.Get(ctx, strKey)
+	ic.stats.Latency.CacheGet.Record(time.Since(startTime))
 	if err != nil {
 		return view, err
 	}
@@ -108,31 +409,48 @@ func (ic *ICache) loadCache(ctx context.Context, strKey string) (View, error) {
 	return view, nil
 }
 
-// load load
-func (ic *ICache) load(ctx context.Context, strKey string, dest SinkIf) (View, bool, error) {
-	ic.stats.AddMiss(1)
+// load load, counters go to st of the calling Get
+func (ic *ICache) load(ctx context.Context, strKey string, strCacheKey string, dest SinkIf, info *OpInfo, st *opStat) (View, bool, error) {
+	st.miss = 1
+	ctx, span := ic.tracer.Start(ctx, SpanLoad)
+	span.SetAttr(AttrKey, strKey)
 	bDestSetView := false
-	viewIf, err := ic.flightGroup.Do(strKey, func() (interface{}, error) {
-		if view, err := ic.loadCache(ctx, strKey); err == nil {
-			// hit
-			ic.stats.AddHit(1)
+	bExecuted := false
+	startTime := time.Now()
+	viewIf, err := ic.flightGroup.Do(strCacheKey, func() (interface{}, error) {
+		bExecuted = true
+		if view, err := ic.loadCache(ctx, strCacheKey); err == nil {
+			// filled by other loader, count as hit instead of miss
+			st.miss, st.hit = 0, 1
 			return view, nil
 		} else if !ic.cache.IsErrNotFound(err) {
 			// loadCache fail, go on
-			ic.stats.AddErr(1)
+			st.err++
 		}
 		// miss
-		ic.stats.AddSource(1)
+		st.source = 1
+		if ic.hotSources != nil {
+			ic.hotSources.Add(strKey)
+		}
+		sourceStartTime := time.Now()
 		view, err := ic.loadSource(ctx, strKey, dest)
+		info.SourceLatency = time.Since(sourceStartTime)
 		if err != nil {
-			ic.stats.AddSourceErr(1)
+			st.sourceErr = 1
 			return nil, err
 		}
-		ic.stats.AddSourceHit(1)
+		st.sourceHit = 1
 		bDestSetView = true
-		ic.setCache(ctx, strKey, view)
+		ic.setCache(ctx, strCacheKey, view)
 		return view, nil
 	})
+	if !bExecuted {
+		// shared result of other goroutine
+		ic.stats.Latency.FlightWait.Record(time.Since(startTime))
+	}
+	info.Shared = !bExecuted
+	span.SetAttr(AttrShared, !bExecuted)
+	span.End(err)
 	if err != nil {
 		return View{}, false, err
 	}
@@ -141,14 +459,24 @@ func (ic *ICache) load(ctx context.Context, strKey string, dest SinkIf) (View, b
 }
 
 // loadSource load source
-func (ic *ICache) loadSource(ctx context.Context, strKey string, dest SinkIf) (View, error) {
+func (ic *ICache) loadSource(ctx context.Context, strKey string, dest SinkIf) (view View, err error) {
+	ctx, span := ic.tracer.Start(ctx, SpanSource)
+	span.SetAttr(AttrKey, strKey)
+	defer func() {
+		span.SetAttr(AttrSourceErr, err != nil)
+		span.End(err)
+	}()
 	// rate limit
 	if ic.rateLimiter != nil {
 		if cnt := ic.rateLimiter.TakeAvailable(1); cnt <= 0 {
 			return View{}, ErrRateLimit
 		}
 	}
-	err := ic.getter.Get(ctx, strKey, dest)
+	startTime := time.Now()
+	err = ic.intercept(ctx, &OpInfo{Op: OpSource, Key: strKey}, func(ctx context.Context, info *OpInfo) error {
+		return ic.getter.Get(ctx, strKey, dest)
+	})
+	ic.stats.Latency.Source.Record(time.Since(startTime))
 	if err != nil {
 		return View{}, err
 	}
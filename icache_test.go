@@ -1,15 +1,28 @@
 package icache
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/iglev/icache/snapshot"
 	json "github.com/json-iterator/go"
+	"github.com/juju/ratelimit"
 )
 
 var (
@@ -101,7 +114,8 @@ func TestObjLRU(t *testing.T) {
 		return
 	}
 
-	objLRU := NewLRUObjCache(10)
+	clock := NewFakeClock(time.Now())
+	objLRU := NewLRUObjCacheWithOpts(10, SetLRUClock(clock))
 	ic, err := NewICache(
 		SetCache(objLRU),
 		SetGetter(GetterIfFunc(getter)),
@@ -145,7 +159,7 @@ func TestObjLRU(t *testing.T) {
 		var stringValue string
 		err = ic.Get(ctx, "stringKey", StringSink(&stringValue))
 		t.Logf("idx=%d stringKey, val=%s err=%+v\n", i, stringValue, err)
-		time.Sleep(1 * time.Second)
+		clock.Advance(time.Second)
 	}
 	t.Logf("for end-------------")
 
@@ -188,7 +202,8 @@ func TestCacheByteLRU(t *testing.T) {
 		return
 	}
 
-	byteLRU := NewLRUByteCache(10)
+	clock := NewFakeClock(time.Now())
+	byteLRU := NewLRUByteCacheWithOpts(10, SetLRUClock(clock))
 	ic, err := NewICache(
 		SetCache(byteLRU),
 		SetGetter(GetterIfFunc(getter)),
@@ -231,7 +246,7 @@ func TestCacheByteLRU(t *testing.T) {
 		var stringValue string
 		err = ic.Get(ctx, "stringKey", StringSink(&stringValue))
 		t.Logf("idx=%d stringKey, val=%s err=%+v\n", i, stringValue, err)
-		time.Sleep(1 * time.Second)
+		clock.Advance(time.Second)
 	}
 	t.Logf("for end-------------")
 	/*
@@ -540,3 +555,1789 @@ func TestObjPage(t *testing.T) {
 		}
 	}
 }
+
+func TestDeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	for _, cache := range []CacheIf{NewLRUObjCache(10), NewLRUByteCache(10)} {
+		ic, err := NewICache(
+			SetCache(cache),
+			SetGetter(GetterIfFunc(getter)),
+		)
+		if err != nil {
+			t.Fatalf("NewICache fail, err=%+v", err)
+		}
+		for _, key := range []string{"user:123:name", "user:123:age", "user:1234:name", "user:456:name"} {
+			cache.Set(ctx, key, "val", 0)
+		}
+		cnt, err := ic.DeletePrefix(ctx, "user:123:")
+		if err != nil || cnt != 2 {
+			t.Fatalf("DeletePrefix cnt=%d err=%+v", cnt, err)
+		}
+		var keys []string
+		cache.(CacheIterIf).Range(ctx, func(key string) bool {
+			keys = append(keys, key)
+			return true
+		})
+		if len(keys) != 2 {
+			t.Fatalf("Range keys=%+v", keys)
+		}
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	cache := NewLRUObjCache(10)
+	ic, err := NewICache(
+		SetCache(cache),
+		SetGetter(GetterIfFunc(getter)),
+		SetNamespace("user", time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	var val string
+	if err := ic.Get(ctx, "stringKey", StringSink(&val)); err != nil || val != "string val" {
+		t.Fatalf("Get val=%s err=%+v", val, err)
+	}
+	if err := ic.Get(ctx, "stringKey", StringSink(&val)); err != nil {
+		t.Fatalf("Get err=%+v", err)
+	}
+	if stat := ic.GetStat(); stat.SourceCnt != 1 {
+		t.Fatalf("stat=%+v", stat)
+	}
+
+	// other process share the same CacheIf
+	ic2, _ := NewICache(
+		SetCache(cache),
+		SetGetter(GetterIfFunc(getter)),
+		SetNamespace("user", 0),
+	)
+	if err := ic2.Get(ctx, "stringKey", StringSink(&val)); err != nil {
+		t.Fatalf("Get err=%+v", err)
+	}
+	if stat := ic2.GetStat(); stat.SourceCnt != 0 {
+		t.Fatalf("stat=%+v", stat)
+	}
+
+	if err := ic2.BumpNamespace(ctx); err != nil {
+		t.Fatalf("BumpNamespace err=%+v", err)
+	}
+	if err := ic2.Get(ctx, "stringKey", StringSink(&val)); err != nil {
+		t.Fatalf("Get err=%+v", err)
+	}
+	if stat := ic2.GetStat(); stat.SourceCnt != 1 {
+		t.Fatalf("stat=%+v", stat)
+	}
+}
+
+func TestNamespaceCapacityChurn(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUObjCacheWithOpts(4, SetLRUClock(clock))
+	ic, err := NewICache(
+		SetCache(cache),
+		SetGetter(GetterIfFunc(func(ctx context.Context, strKey string, dest SinkIf) error {
+			return dest.SetString(strKey)
+		})),
+		SetNamespace("churn", time.Minute),
+		SetClock(clock),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	strCacheKey, _ := ic.cacheKey(ctx, "key")
+	var val string
+	for i := 0; i < 100; i++ {
+		ic.Get(ctx, strconv.Itoa(i), StringSink(&val))
+	}
+	ic.Get(ctx, "key", StringSink(&val))
+	// local gen expired, reload gen from CacheIf
+	clock.Advance(2 * time.Minute)
+	// gen survives capacity eviction, key is still cached
+	if strNow, _ := ic.cacheKey(ctx, "key"); strNow != strCacheKey {
+		t.Fatalf("gen changed, before=%s after=%s", strCacheKey, strNow)
+	}
+	ic.Get(ctx, "key", StringSink(&val))
+	if stat := ic.GetStat(); stat.SourceCnt != 101 {
+		t.Fatalf("stat=%+v", stat)
+	}
+}
+
+func TestNamespaceConcurrentInit(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUObjCache(10)
+	gens := make([]int64, 16)
+	var wg sync.WaitGroup
+	for i := range gens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ns := newNamespace("ns", time.Minute)
+			ns.clock = NewFakeClock(time.Unix(0, int64(i+1)))
+			gens[i], _ = ns.getGen(ctx, cache)
+		}(i)
+	}
+	wg.Wait()
+	for _, gen := range gens {
+		if gen == 0 || gen != gens[0] {
+			t.Fatalf("gens=%+v", gens)
+		}
+	}
+}
+
+func TestTTLJitter(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUObjCacheWithOpts(10, SetLRUClock(clock))
+	ic, err := NewICache(
+		SetCache(cache),
+		SetGetter(GetterIfFunc(func(ctx context.Context, strKey string, dest SinkIf) error {
+			dest.SetString(strKey)
+			return SetSinkTTLDuration(dest, 100*time.Millisecond)
+		})),
+		SetTTLJitter(0.5),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	var val string
+	if err := ic.Get(ctx, "key", StringSink(&val)); err != nil || val != "key" {
+		t.Fatalf("Get val=%s err=%+v", val, err)
+	}
+	if _, err := cache.Get(ctx, "key"); err != nil {
+		t.Fatalf("cache Get err=%+v", err)
+	}
+	clock.Advance(99 * time.Millisecond)
+	if _, err := cache.Get(ctx, "key"); err != nil {
+		t.Fatalf("cache Get before ttl err=%+v", err)
+	}
+	clock.Advance(51 * time.Millisecond)
+	if _, err := cache.Get(ctx, "key"); !cache.IsErrNotFound(err) {
+		t.Fatalf("cache Get should expire, err=%+v", err)
+	}
+	for i := 0; i < 100; i++ {
+		ttl := ic.jitterTTL(time.Second)
+		if ttl < time.Second || ttl >= 1500*time.Millisecond {
+			t.Fatalf("jitterTTL ttl=%v", ttl)
+		}
+	}
+}
+
+func TestIdleTTL(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUByteCacheWithOpts(10, SetLRUClock(clock))
+	ic, err := NewICache(
+		SetCache(cache),
+		SetGetter(GetterIfFunc(func(ctx context.Context, strKey string, dest SinkIf) error {
+			dest.SetString(strKey)
+			if strKey == "session" {
+				SetSinkIdleTTL(dest, 100*time.Millisecond)
+			}
+			return nil
+		})),
+		SetIdleTTL(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	var val string
+	ic.Get(ctx, "session", StringSink(&val))
+	ic.Get(ctx, "other", StringSink(&val))
+	for i := 0; i < 4; i++ {
+		clock.Advance(60 * time.Millisecond)
+		if _, err := cache.Get(ctx, "session"); err != nil {
+			t.Fatalf("idx=%d cache Get err=%+v", i, err)
+		}
+	}
+	clock.Advance(101 * time.Millisecond)
+	if _, err := cache.Get(ctx, "session"); !cache.IsErrNotFound(err) {
+		t.Fatalf("cache Get should expire, err=%+v", err)
+	}
+	if _, err := cache.Get(ctx, "other"); err != nil {
+		t.Fatalf("cache Get err=%+v", err)
+	}
+
+	// idle timeout needs CacheExpireIf
+	plain := struct{ CacheIf }{NewLRUObjCache(10)}
+	if _, err := NewICache(SetCache(plain), SetGetter(GetterIfFunc(getter)), SetIdleTTL(time.Hour)); err != ErrNotSupport {
+		t.Fatalf("NewICache err=%+v", err)
+	}
+	ic, _ = NewICache(
+		SetCache(plain),
+		SetGetter(GetterIfFunc(func(ctx context.Context, strKey string, dest SinkIf) error {
+			dest.SetString(strKey)
+			return SetSinkIdleTTL(dest, time.Minute)
+		})),
+	)
+	if err := ic.Get(ctx, "session", StringSink(&val)); err != nil || val != "session" {
+		t.Fatalf("Get val=%s err=%+v", val, err)
+	}
+	if _, err := plain.Get(ctx, "session"); !plain.IsErrNotFound(err) {
+		t.Fatalf("value with idle timeout should not be cached, err=%+v", err)
+	}
+}
+
+func TestLRUJanitor(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	expired := map[string]interface{}{}
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUObjCacheWithOpts(100,
+		SetLRUClock(clock),
+		SetLRUJanitor(10*time.Millisecond, 10),
+		SetLRUEvictFunc(func(strKey string, valIf interface{}, reason EvictReason) {
+			if reason != EvictExpired {
+				t.Errorf("key=%s reason=%s", strKey, reason)
+			}
+			mu.Lock()
+			expired[strKey] = valIf
+			mu.Unlock()
+		}),
+	)
+	defer cache.(io.Closer).Close()
+	for i := 0; i < 50; i++ {
+		cache.(CacheExpireIf).SetWithExpire(ctx, strconv.Itoa(i), i, ExpireOpt{TTL: 20 * time.Millisecond})
+	}
+	cache.Set(ctx, "forever", "val", 0)
+	clock.Advance(20 * time.Millisecond)
+	// 51 keys, 10 per tick, wait each tick handled before next one
+	for i := 0; i < 6; i++ {
+		clock.Advance(10 * time.Millisecond)
+		waitFor(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(expired) >= (i+1)*10 || len(expired) == 50
+		})
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(expired) != 50 || expired["7"] != 7 {
+		t.Fatalf("expired=%+v", expired)
+	}
+	if _, err := cache.Get(ctx, "forever"); err != nil {
+		t.Fatalf("cache Get err=%+v", err)
+	}
+}
+
+func TestLRUJanitorCursor(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	c := newLRUBase(100, SetLRUClock(clock), SetLRUJanitor(time.Hour, 2))
+	defer c.Close()
+	for i := 0; i < 5; i++ {
+		c.set(strconv.Itoa(i), i, ExpireOpt{TTL: time.Second})
+	}
+	c.set("forever", "val", ExpireOpt{})
+	// keys never expire are not scanned
+	if c.scan.Len() != 5 {
+		t.Fatalf("scan len=%d", c.scan.Len())
+	}
+	clock.Advance(2 * time.Second)
+	if cnt := c.removeExpired(2); cnt != 2 || c.scanNext.Value != "2" {
+		t.Fatalf("cnt=%d", cnt)
+	}
+	// cursor key removed by others, scan goes on from next key
+	c.Del(context.Background(), "2")
+	if cnt := c.removeExpired(2); cnt != 2 || c.scanNext != nil {
+		t.Fatalf("cnt=%d", cnt)
+	}
+	c.set("5", 5, ExpireOpt{Idle: time.Second})
+	clock.Advance(2 * time.Second)
+	if cnt := c.removeExpired(2); cnt != 1 || c.scan.Len() != 0 || c.Len() != 1 {
+		t.Fatalf("cnt=%d len=%d", cnt, c.Len())
+	}
+}
+
+func TestLRURawEvict(t *testing.T) {
+	ctx := context.Background()
+	evicted := map[interface{}]interface{}{}
+	cache := NewLRUObjCacheWithEvict(2, func(key interface{}, value interface{}) {
+		evicted[key] = value
+	})
+	cache.Set(ctx, "a", 1, 0)
+	cache.Set(ctx, "a", 2, 0)
+	if len(evicted) != 0 {
+		t.Fatalf("replace fire raw evict, evicted=%+v", evicted)
+	}
+	cache.Set(ctx, "b", 3, 0)
+	cache.Set(ctx, "c", 4, 0)
+	cache.Del(ctx, "b")
+	if item, ok := evicted["a"].(*lruItem); !ok || item.val != 2 {
+		t.Fatalf("evicted=%+v", evicted)
+	}
+	if item, ok := evicted["b"].(*lruItem); !ok || item.val != 3 {
+		t.Fatalf("evicted=%+v", evicted)
+	}
+}
+
+func TestLRUConcurrentAccounting(t *testing.T) {
+	ctx := context.Background()
+	var removed int64
+	cache := NewLRUByteCacheWithOpts(8, SetLRUEvictFunc(func(strKey string, valIf interface{}, reason EvictReason) {
+		atomic.AddInt64(&removed, 1)
+	}))
+	var sets int64
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				strKey := strconv.Itoa((g + i) % 16)
+				if i%3 == 0 {
+					cache.Del(ctx, strKey)
+					continue
+				}
+				cache.Set(ctx, strKey, []byte("val"), 0)
+				atomic.AddInt64(&sets, 1)
+			}
+		}(g)
+	}
+	wg.Wait()
+	for i := 0; i < 16; i++ {
+		cache.Del(ctx, strconv.Itoa(i))
+	}
+	// every value set is removed exactly once
+	if stat := cache.(CacheStatIf).Stat(); stat.Bytes != 0 || stat.Len != 0 || removed != sets {
+		t.Fatalf("stat=%+v removed=%d sets=%d", stat, removed, sets)
+	}
+}
+
+func TestEvictFunc(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	cache := NewLRUObjCache(2)
+	reasons := map[string]EvictReason{}
+	ic, err := NewICache(
+		SetCache(cache),
+		SetGetter(GetterIfFunc(getter)),
+		SetNamespace("ns", time.Minute),
+		SetEvictFunc(func(strKey string, valIf interface{}, reason EvictReason) {
+			reasons[strKey] = reason
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	var val string
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	ic.Delete(ctx, "stringKey")
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	ic.Get(ctx, "byteKey", StringSink(&val))
+	if reasons["stringKey"] != EvictDeleted {
+		t.Fatalf("reasons=%+v", reasons)
+	}
+	// namespace gen key is pinned, not evicted by capacity
+	stat := ic.GetStat()
+	if stat.EvictDeletedCnt != 1 || stat.EvictCapacityCnt != 0 {
+		t.Fatalf("stat=%+v", stat)
+	}
+
+	strCacheKey, _ := ic.cacheKey(ctx, "byteKey")
+	cache.Set(ctx, strCacheKey, "new val", 0)
+	if reasons["byteKey"] != EvictReplaced {
+		t.Fatalf("reasons=%+v", reasons)
+	}
+	ic.Get(ctx, "stringSink_SetBytes", StringSink(&val))
+	if reasons["stringKey"] != EvictCapacity {
+		t.Fatalf("reasons=%+v", reasons)
+	}
+}
+
+// closeCounter CacheIf counting Flush and Close
+type closeCounter struct {
+	CacheIf
+	flushed int32
+	closed  int32
+}
+
+func (c *closeCounter) Flush(ctx context.Context) error {
+	atomic.AddInt32(&c.flushed, 1)
+	return nil
+}
+
+func (c *closeCounter) Close() error {
+	atomic.AddInt32(&c.closed, 1)
+	return nil
+}
+
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+	cache := &closeCounter{CacheIf: NewLRUObjCache(10)}
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	newICache := func(opts ...Option) *ICache {
+		ic, err := NewICache(append([]Option{
+			SetCache(cache),
+			SetGetter(GetterIfFunc(func(ctx context.Context, strKey string, dest SinkIf) error {
+				loading <- struct{}{}
+				<-release
+				return dest.SetString(strKey)
+			})),
+		}, opts...)...)
+		if err != nil {
+			t.Fatalf("NewICache fail, err=%+v", err)
+		}
+		return ic
+	}
+
+	// timeout, in-flight Get keeps using CacheIf
+	ic := newICache(SetCloseCache(true))
+	getErr := make(chan error)
+	go func() {
+		var val string
+		getErr <- ic.Get(ctx, "key", StringSink(&val))
+	}()
+	<-loading
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := ic.Close(timeoutCtx); err != context.DeadlineExceeded || atomic.LoadInt32(&cache.closed) != 0 {
+		t.Fatalf("Close err=%+v closed=%d", err, cache.closed)
+	}
+	close(release)
+	if err := <-getErr; err != nil {
+		t.Fatalf("in-flight Get err=%+v", err)
+	}
+	var val string
+	if err := ic.Get(ctx, "key", StringSink(&val)); err != ErrClosed {
+		t.Fatalf("Get after Close err=%+v", err)
+	}
+	// retry goes on, flush and close once
+	for i := 0; i < 2; i++ {
+		if err := ic.Close(ctx); err != nil || atomic.LoadInt32(&cache.flushed) != 1 || atomic.LoadInt32(&cache.closed) != 1 {
+			t.Fatalf("Close err=%+v flushed=%d closed=%d", err, cache.flushed, cache.closed)
+		}
+	}
+	atomic.StoreInt32(&cache.flushed, 0)
+	atomic.StoreInt32(&cache.closed, 0)
+
+	// shared CacheIf is not closed, owned one is closed after in-flight ops
+	ic = newICache()
+	if err := ic.Close(ctx); err != nil || cache.closed != 0 {
+		t.Fatalf("Close err=%+v closed=%d", err, cache.closed)
+	}
+	ic = newICache(SetCloseCache(true))
+	go ic.Get(ctx, "key2", StringSink(&val))
+	<-loading
+	if err := ic.Close(ctx); err != nil || atomic.LoadInt32(&cache.closed) != 1 {
+		t.Fatalf("Close err=%+v closed=%d", err, cache.closed)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	cache := NewLRUObjCache(100)
+	ic, err := NewICache(
+		SetCache(cache),
+		SetGetter(GetterIfFunc(getter)),
+		SetRateLimit(100),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	var buf strings.Builder
+	WriteKeys(&buf, []string{"stringKey", "byteKey", "objKey", "unknownKey"})
+	var progressCnt int64
+	stat, err := ic.Warm(ctx, ReaderKeyIter(strings.NewReader("# hot keys\n"+buf.String())), 2, func(stat WarmStat) {
+		progressCnt++
+	})
+	if err != nil {
+		t.Fatalf("Warm err=%+v", err)
+	}
+	if stat.Total != 4 || stat.Loaded != 3 || stat.Failed != 1 || stat.Errs[0].Key != "unknownKey" || progressCnt != 4 {
+		t.Fatalf("stat=%+v progressCnt=%d", stat, progressCnt)
+	}
+	var objValue nodeObj
+	if err := ic.Get(ctx, "objKey", ObjSink(&objValue)); err != nil || objValue.Num != 10 {
+		t.Fatalf("Get objValue=%+v err=%+v", objValue, err)
+	}
+	if stat := ic.GetStat(); stat.SourceCnt != 4 {
+		t.Fatalf("stat=%+v", stat)
+	}
+}
+
+func TestWarmGetterRateLimit(t *testing.T) {
+	ctx := context.Background()
+	var calls int64
+	ic, err := NewICache(
+		SetCache(NewLRUObjCache(10)),
+		SetGetter(GetterIfFunc(func(ctx context.Context, strKey string, dest SinkIf) error {
+			// rate limited by source, ICache has no rate limiter
+			if atomic.AddInt64(&calls, 1) <= 2 {
+				return ErrRateLimit
+			}
+			return dest.SetString(strKey)
+		})),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	stat, err := ic.Warm(ctx, SliceKeyIter([]string{"key"}), 1, nil)
+	if err != nil || stat.Loaded != 1 || calls != 3 {
+		t.Fatalf("stat=%+v calls=%d err=%+v", stat, calls, err)
+	}
+	if wait := ic.warmBackoff(); wait != defaultWarmBackoff {
+		t.Fatalf("warmBackoff=%s", wait)
+	}
+	ic.rateLimiter = ratelimit.NewBucketWithRate(0.1, 1)
+	if wait := ic.warmBackoff(); wait != maxWarmBackoff {
+		t.Fatalf("warmBackoff=%s", wait)
+	}
+	ic.rateLimiter = ratelimit.NewBucketWithRate(1e6, 1)
+	if wait := ic.warmBackoff(); wait != minWarmBackoff {
+		t.Fatalf("warmBackoff=%s", wait)
+	}
+}
+
+func TestHotKeyTrackerShards(t *testing.T) {
+	h := newHotKeyTracker(64, time.Minute)
+	h.clock = NewFakeClock(time.Now())
+	if len(h.shards) != 4 {
+		t.Fatalf("shards=%d", len(h.shards))
+	}
+	// key i is accessed 10-i times, spread over shards without eviction
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				for j := 0; j < 10-i; j++ {
+					h.Add("key" + strconv.Itoa(i))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	want := make([]HotKey, 0, 5)
+	for i := 0; i < 5; i++ {
+		want = append(want, HotKey{Key: "key" + strconv.Itoa(i), Count: int64(4 * (10 - i))})
+	}
+	if top := h.Top(5); !reflect.DeepEqual(top, want) {
+		t.Fatalf("top=%+v want=%+v", top, want)
+	}
+	if top := h.Top(0); len(top) != 10 || top[9].Key != "key9" || top[9].Count != 4 {
+		t.Fatalf("top=%+v", top)
+	}
+	if len(newHotKeyTracker(8, time.Minute).shards) != 1 || len(newHotKeyTracker(1<<20, time.Minute).shards) != hotKeyShards {
+		t.Fatalf("shard cnt")
+	}
+}
+
+func TestHotKeys(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	ic, err := NewICache(
+		SetCache(NewLRUObjCache(100)),
+		SetGetter(GetterIfFunc(getter)),
+		SetHotKeys(8, time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	var val string
+	for i := 0; i < 100; i++ {
+		ic.Get(ctx, "stringKey", StringSink(&val))
+		if i%2 == 0 {
+			ic.Get(ctx, "unknownKey", StringSink(&val))
+		}
+		ic.Get(ctx, "key"+strconv.Itoa(i), StringSink(&val))
+	}
+	// true counts are inside [Count-Err, Count]
+	hotKeys := ic.HotKeys(2)
+	if len(hotKeys) != 2 ||
+		hotKeys[0].Key != "stringKey" || hotKeys[0].Count < 100 || hotKeys[0].Count-hotKeys[0].Err > 100 ||
+		hotKeys[1].Key != "unknownKey" || hotKeys[1].Count < 50 || hotKeys[1].Count-hotKeys[1].Err > 50 {
+		t.Fatalf("hotKeys=%+v", hotKeys)
+	}
+	hotSources := ic.HotSourceKeys(1)
+	if len(hotSources) != 1 || hotSources[0].Key != "unknownKey" {
+		t.Fatalf("hotSources=%+v", hotSources)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	var h Histogram
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	if h.Count() != 1000 || h.Mean() != 500500*time.Nanosecond {
+		t.Fatalf("count=%d mean=%v", h.Count(), h.Mean())
+	}
+	for _, c := range []struct {
+		p    float64
+		want time.Duration
+	}{{50, 500 * time.Microsecond}, {99, 990 * time.Microsecond}, {100, 1000 * time.Microsecond}} {
+		got := h.Percentile(c.p)
+		if got < c.want || got > c.want+c.want/8 {
+			t.Fatalf("p%v=%v want=%v", c.p, got, c.want)
+		}
+	}
+
+	ctx := context.WithValue(context.Background(), "testing", t)
+	ic, _ := NewICache(
+		SetCache(NewLRUObjCache(10)),
+		SetGetter(GetterIfFunc(getter)),
+	)
+	var val string
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	lat := ic.GetStat().Latency
+	if lat.CacheGet.Count() != 3 || lat.CacheSet.Count() != 1 || lat.Source.Count() != 1 || lat.FlightWait.Count() != 0 {
+		t.Fatalf("lat=%+v", lat)
+	}
+}
+
+func TestPromHandler(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	ic, _ := NewICache(
+		SetCache(NewLRUObjCache(10)),
+		SetGetter(GetterIfFunc(getter)),
+	)
+	var val string
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	reg := NewRegistry()
+	reg.Register(`user"cache`, ic)
+
+	rec := httptest.NewRecorder()
+	NewPromHandler(reg).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`# TYPE icache_get_total counter`,
+		`icache_get_total{cache="user\"cache"} 2`,
+		`icache_hit_total{cache="user\"cache"} 1`,
+		`icache_evict_total{cache="user\"cache",reason="capacity"} 0`,
+		`icache_latency_seconds_count{cache="user\"cache",op="source"} 1`,
+		`icache_backend_items{cache="user\"cache"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("line=%s not found, body=%s", line, body)
+		}
+	}
+}
+
+func TestStatsSnapshot(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	ic, _ := NewICache(
+		SetCache(NewLRUObjCache(10)),
+		SetGetter(GetterIfFunc(getter)),
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				var val string
+				ic.Get(ctx, "stringKey", StringSink(&val))
+				ic.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+	prev := ic.Snapshot()
+	if prev.GetCnt != 400 || prev.HitRatio() < 0.99 {
+		t.Fatalf("prev=%+v", prev)
+	}
+	var val string
+	ic.Get(ctx, "unknownKey", StringSink(&val))
+	delta := ic.Snapshot().Delta(prev)
+	if delta.GetCnt != 1 || delta.HitRatio() != 0 || delta.SourceErrRatio() != 1 || delta.Interval <= 0 {
+		t.Fatalf("delta=%+v", delta)
+	}
+	ic.ResetStat()
+	if stat := ic.GetStat(); stat.GetCnt != 0 || stat.Latency.CacheGet.Count() != 0 {
+		t.Fatalf("stat=%+v", stat)
+	}
+}
+
+func TestStatsConsistent(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	ic, _ := NewICache(
+		SetCache(NewLRUObjCache(8)),
+		SetGetter(GetterIfFunc(getter)),
+	)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				var val string
+				ic.Get(ctx, []string{"stringKey", "byteKey", "unknownKey"}[(i+j)%3], StringSink(&val))
+				if j%50 == 0 {
+					ic.Delete(ctx, "stringKey")
+				}
+			}
+		}(i)
+	}
+	checked := make(chan struct{})
+	go func() {
+		defer close(checked)
+		for n := 0; ; n++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			snap := ic.Snapshot()
+			if snap.HitCnt+snap.MissCnt != snap.GetCnt || snap.HitRatio() > 1 {
+				t.Errorf("torn snapshot=%+v", snap)
+				return
+			}
+			if n%10 == 0 {
+				ic.ResetStat()
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-checked
+	if snap := ic.Snapshot(); snap.HitCnt+snap.MissCnt != snap.GetCnt {
+		t.Fatalf("snap=%+v", snap)
+	}
+}
+
+func TestBackendStats(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUByteCacheWithOpts(2, SetLRUClock(clock))
+	ic, _ := NewICache(
+		SetCache(cache),
+		SetGetter(GetterIfFunc(getter)),
+	)
+	var val string
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	ic.Get(ctx, "byteKey", StringSink(&val))
+	cache.Set(ctx, "byteKey", "v", 0)
+	ic.Get(ctx, "stringSink_SetBytes", StringSink(&val))
+	cache.(CacheExpireIf).SetWithExpire(ctx, "expireKey", "v", ExpireOpt{TTL: time.Millisecond})
+	clock.Advance(2 * time.Millisecond)
+	cache.Get(ctx, "expireKey")
+
+	snap := ic.Snapshot()
+	want := BackendStats{
+		Len:         1,
+		Capacity:    2,
+		Bytes:       int64(len("stringSink_SetBytes") + len("stringSink set bytes")),
+		Evictions:   2,
+		Expirations: 1,
+	}
+	if !snap.HasBackend || snap.Backend != want {
+		t.Fatalf("backend=%+v want=%+v", snap.Backend, want)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	tracer := NewRecordTracer()
+	ic, _ := NewICache(
+		SetCache(NewLRUObjCache(10)),
+		SetGetter(GetterIfFunc(getter)),
+		SetTracer(tracer),
+	)
+	var val string
+	ic.Get(ctx, "unknownKey", StringSink(&val))
+	spans := tracer.Spans()
+	if len(spans) != 3 || spans[0].Name != SpanSource || spans[1].Name != SpanLoad || spans[2].Name != SpanGet {
+		t.Fatalf("spans=%+v", spans)
+	}
+	if spans[0].ParentID != spans[1].ID || spans[1].ParentID != spans[2].ID || spans[2].ParentID != 0 {
+		t.Fatalf("spans=%+v", spans)
+	}
+	if spans[0].Attrs[AttrSourceErr] != true || spans[1].Attrs[AttrShared] != false ||
+		spans[2].Attrs[AttrHit] != false || spans[2].Attrs[AttrKey] != "unknownKey" || spans[2].Err == nil {
+		t.Fatalf("spans=%+v", spans)
+	}
+
+	tracer.Reset()
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	spans = tracer.Spans()
+	if len(spans) != 4 || spans[3].Name != SpanGet || spans[3].Attrs[AttrHit] != true || spans[3].Err != nil {
+		t.Fatalf("spans=%+v", spans)
+	}
+
+	// recorded span does not share attrs with live span
+	tracer.Reset()
+	_, span := tracer.Start(ctx, "live")
+	span.SetAttr("a", 1)
+	span.End(nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			span.SetAttr("b", i)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_ = tracer.Spans()[0].Attrs["b"]
+	}
+	<-done
+	if spans = tracer.Spans(); len(spans[0].Attrs) != 1 || spans[0].Attrs["a"] != 1 {
+		t.Fatalf("spans=%+v", spans)
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	var ops []string
+	errInvalidKey := fmt.Errorf("invalid key")
+	ic, _ := NewICache(
+		SetCache(NewLRUObjCache(10)),
+		SetGetter(GetterIfFunc(getter)),
+		SetInterceptors(
+			func(ctx context.Context, info *OpInfo, next Handler) error {
+				err := next(ctx, info)
+				ops = append(ops, fmt.Sprintf("%s:%s:hit=%v:err=%v", info.Op, info.Key, info.Hit, err != nil))
+				return err
+			},
+			func(ctx context.Context, info *OpInfo, next Handler) error {
+				if strings.HasPrefix(info.Key, "forbidden") {
+					return errInvalidKey
+				}
+				return next(ctx, info)
+			},
+		),
+	)
+	var val string
+	if err := ic.Get(ctx, "forbiddenKey", StringSink(&val)); err != errInvalidKey {
+		t.Fatalf("Get err=%+v", err)
+	}
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	ic.Delete(ctx, "stringKey")
+	if _, err := ic.DeletePrefix(ctx, "forbidden"); err != errInvalidKey {
+		t.Fatalf("DeletePrefix err=%+v", err)
+	}
+	if cnt, err := ic.DeletePrefix(ctx, "string"); err != nil || cnt != 0 {
+		t.Fatalf("DeletePrefix cnt=%d err=%+v", cnt, err)
+	}
+	if err := ic.Purge(ctx); err != nil {
+		t.Fatalf("Purge err=%+v", err)
+	}
+	if err := ic.BumpNamespace(ctx); err != ErrNamespace {
+		t.Fatalf("BumpNamespace err=%+v", err)
+	}
+	want := []string{
+		"get:forbiddenKey:hit=false:err=true",
+		"source:stringKey:hit=false:err=false",
+		"load:stringKey:hit=false:err=false",
+		"get:stringKey:hit=false:err=false",
+		"get:stringKey:hit=true:err=false",
+		"delete:stringKey:hit=false:err=false",
+		"delete_prefix:forbidden:hit=false:err=true",
+		"delete_prefix:string:hit=false:err=false",
+		"delete_prefix::hit=false:err=false",
+		"bump_namespace::hit=false:err=true",
+	}
+	if !reflect.DeepEqual(ops, want) {
+		t.Fatalf("ops=%+v", ops)
+	}
+	// short-circuited Get counted, short-circuited DeletePrefix not
+	if stat := ic.GetStat(); stat.GetCnt != 3 || stat.HitCnt != 1 || stat.MissCnt != 1 || stat.DelCnt != 3 {
+		t.Fatalf("stat=%+v", stat)
+	}
+
+	// namespace ops through chain
+	ops = nil
+	icNs, _ := NewICache(
+		SetCache(NewLRUObjCache(10)),
+		SetGetter(GetterIfFunc(getter)),
+		SetNamespace("user", 0),
+		SetInterceptors(func(ctx context.Context, info *OpInfo, next Handler) error {
+			ops = append(ops, fmt.Sprintf("%s:%s", info.Op, info.Key))
+			return next(ctx, info)
+		}),
+	)
+	icNs.Purge(ctx)
+	if !reflect.DeepEqual(ops, []string{"bump_namespace:user"}) {
+		t.Fatalf("ops=%+v", ops)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	var recs []AccessRecord
+	ic, _ := NewICache(
+		SetCache(NewLRUObjCache(10)),
+		SetGetter(GetterIfFunc(getter)),
+		SetAccessLog(AccessLoggerFunc(func(rec AccessRecord) {
+			recs = append(recs, rec)
+		}), AccessLogOpt{
+			KeyFilter: func(strKey string) bool {
+				return strKey != "byteKey"
+			},
+		}),
+	)
+	var val string
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	ic.Get(ctx, "unknownKey", StringSink(&val))
+	ic.Get(ctx, "byteKey", StringSink(&val))
+	ic.Delete(ctx, "stringKey")
+	if len(recs) != 4 {
+		t.Fatalf("recs=%+v", recs)
+	}
+	if recs[0].Result != AccessMiss || recs[0].SourceLatency <= 0 || recs[1].Result != AccessHit ||
+		recs[2].Result != AccessError || recs[3].Op != OpDelete || recs[3].Result != AccessOK {
+		t.Fatalf("recs=%+v", recs)
+	}
+
+	// key sampling is stable
+	opt := AccessLogOpt{KeyRate: 0.5}
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		strKey := strconv.Itoa(i)
+		if opt.sampled(strKey) != opt.sampled(strKey) {
+			t.Fatalf("key=%s sampling not stable", strKey)
+		}
+		if opt.sampled(strKey) {
+			sampled++
+		}
+	}
+	if sampled < 400 || sampled > 600 {
+		t.Fatalf("sampled=%d", sampled)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	ic, _ := NewICache(
+		SetCache(NewLRUByteCache(10)),
+		SetGetter(GetterIfFunc(getter)),
+		SetHotKeys(10, time.Minute),
+	)
+	var val string
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	ic.Get(ctx, "byteKey", StringSink(&val))
+	reg := NewRegistry()
+	reg.Register("user", ic)
+	h := NewAdminHandler(reg, func(r *http.Request) bool {
+		return r.Header.Get("Token") == "secret"
+	})
+	do := func(strMethod string, strURL string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(strMethod, strURL, nil)
+		req.Header.Set("Token", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var body map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/caches", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("code=%d", rec.Code)
+	}
+	if code, body := do("GET", "/key?cache=user&key=stringKey"); code != http.StatusOK ||
+		body["value"] != "string val" || body["ttl_ms"].(float64) <= 0 {
+		t.Fatalf("code=%d body=%+v", code, body)
+	}
+	if code, _ := do("GET", "/key?cache=user&key=unknownKey"); code != http.StatusNotFound {
+		t.Fatalf("code=%d", code)
+	}
+	if code, _ := do("GET", "/delete?cache=user&key=stringKey"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("code=%d", code)
+	}
+	if code, body := do("POST", "/delete_prefix?cache=user&prefix=string"); code != http.StatusOK || body["deleted"] != float64(1) {
+		t.Fatalf("code=%d body=%+v", code, body)
+	}
+	if code, body := do("GET", "/hotkeys?cache=user&n=1"); code != http.StatusOK || len(body["hot_keys"].([]interface{})) != 1 {
+		t.Fatalf("code=%d body=%+v", code, body)
+	}
+	if code, _ := do("POST", "/purge?cache=user"); code != http.StatusOK {
+		t.Fatalf("code=%d", code)
+	}
+	if snap := ic.Snapshot(); snap.Backend.Len != 0 {
+		t.Fatalf("snap=%+v", snap)
+	}
+}
+
+func TestDump(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUObjCache(10)
+	cache.Set(ctx, "bytes", []byte{0xff, 0x00}, 0)
+	cache.Set(ctx, "string", "val", 10)
+	cache.Set(ctx, "obj", &nodeObj{Num: 1}, 0)
+	cache.Set(ctx, "chan", make(chan int), 0)
+	ic, _ := NewICache(SetCache(cache), SetGetter(GetterIfFunc(getter)))
+	var buf bytes.Buffer
+	cnt, err := ic.Dump(ctx, &buf)
+	if err != nil || cnt != 3 {
+		t.Fatalf("Dump cnt=%d err=%+v", cnt, err)
+	}
+
+	var v1 bytes.Buffer
+	if _, err := snapshot.Convert(&v1, bytes.NewReader(buf.Bytes()), snapshot.Version1); err != nil {
+		t.Fatalf("Convert err=%+v", err)
+	}
+	sr, err := snapshot.NewReader(&v1)
+	if err != nil || sr.Version() != snapshot.Version1 {
+		t.Fatalf("NewReader err=%+v", err)
+	}
+	recs := map[string]*snapshot.Record{}
+	for {
+		rec, err := sr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read err=%+v", err)
+		}
+		recs[rec.Key] = rec
+	}
+	if len(recs) != 3 || recs["bytes"].Kind != snapshot.KindBytes || string(recs["obj"].Value) != `{"Num":1,"Key":"","Vec":null}` ||
+		recs["string"].TTL(time.Now()) <= 9*time.Second {
+		t.Fatalf("recs=%+v", recs)
+	}
+
+	// namespace: only current generation with user keys
+	shared := NewLRUObjCache(10)
+	icA, _ := NewICache(SetCache(shared), SetGetter(GetterIfFunc(getter)), SetNamespace("a", 0))
+	icB, _ := NewICache(SetCache(shared), SetGetter(GetterIfFunc(getter)), SetNamespace("b", 0))
+	var val string
+	getCtx := context.WithValue(ctx, "testing", t)
+	icA.Get(getCtx, "byteKey", StringSink(&val))
+	icB.Get(getCtx, "byteKey", StringSink(&val))
+	icA.BumpNamespace(ctx)
+	icA.Get(getCtx, "stringKey", StringSink(&val))
+	var nsBuf bytes.Buffer
+	if cnt, err := icA.Dump(ctx, &nsBuf); err != nil || cnt != 1 {
+		t.Fatalf("Dump cnt=%d err=%+v", cnt, err)
+	}
+	sr, _ = snapshot.NewReader(&nsBuf)
+	if rec, err := sr.Read(); err != nil || rec.Key != "stringKey" {
+		t.Fatalf("Read rec=%+v err=%+v", rec, err)
+	}
+	// no namespace: gen keys skipped
+	icAll, _ := NewICache(SetCache(shared), SetGetter(GetterIfFunc(getter)))
+	nsBuf.Reset()
+	if cnt, err := icAll.Dump(ctx, &nsBuf); err != nil || cnt != 3 {
+		t.Fatalf("Dump cnt=%d err=%+v", cnt, err)
+	}
+
+	// corrupt v2 record
+	b := buf.Bytes()
+	b[len(b)-1] ^= 0xff
+	sr, _ = snapshot.NewReader(bytes.NewReader(b))
+	for err == nil {
+		_, err = sr.Read()
+	}
+	if err != snapshot.ErrChecksum {
+		t.Fatalf("Read err=%+v", err)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	ticker := clock.NewTicker(time.Second)
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-ticker.Chan():
+		t.Fatalf("ticker fired early")
+	default:
+	}
+	// ticks are dropped for slow receiver
+	clock.Advance(3 * time.Second)
+	if now := <-ticker.Chan(); !now.Equal(start.Add(3999 * time.Millisecond)) {
+		t.Fatalf("tick=%v", now)
+	}
+	select {
+	case <-ticker.Chan():
+		t.Fatalf("ticker should drop ticks")
+	default:
+	}
+	clock.Advance(time.Second)
+	<-ticker.Chan()
+	ticker.Stop()
+	clock.Advance(time.Minute)
+	select {
+	case <-ticker.Chan():
+		t.Fatalf("stopped ticker fired")
+	default:
+	}
+}
+
+func TestClockWindows(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	clock := NewFakeClock(time.Now())
+	cache := NewLRUObjCacheWithOpts(100, SetLRUClock(clock))
+	ic, err := NewICache(
+		SetCache(cache),
+		SetGetter(GetterIfFunc(getter)),
+		SetNamespace("user", time.Minute),
+		SetHotKeys(8, time.Minute),
+		SetClock(clock),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	var val string
+	ic.Get(ctx, "stringKey", StringSink(&val))
+
+	// other process bumps namespace, local gen is used until local ttl
+	ic2, _ := NewICache(SetCache(cache), SetGetter(GetterIfFunc(getter)), SetNamespace("user", 0))
+	ic2.BumpNamespace(ctx)
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	if stat := ic.GetStat(); stat.SourceCnt != 1 {
+		t.Fatalf("stat=%+v", stat)
+	}
+	clock.Advance(time.Minute)
+	ic.Get(ctx, "stringKey", StringSink(&val))
+	if stat := ic.GetStat(); stat.SourceCnt != 2 {
+		t.Fatalf("stat=%+v", stat)
+	}
+
+	// hot keys of previous window are kept for one more window
+	if hotKeys := ic.HotKeys(1); len(hotKeys) != 1 || hotKeys[0].Key != "stringKey" || hotKeys[0].Count != 3 {
+		t.Fatalf("hotKeys=%+v", hotKeys)
+	}
+	clock.Advance(time.Minute)
+	if hotKeys := ic.HotKeys(1); len(hotKeys) != 1 || hotKeys[0].Count != 1 {
+		t.Fatalf("hotKeys=%+v", hotKeys)
+	}
+	clock.Advance(time.Minute)
+	if hotKeys := ic.HotKeys(1); len(hotKeys) != 0 {
+		t.Fatalf("hotKeys=%+v", hotKeys)
+	}
+	if snap := ic.Snapshot(); !snap.Time.Equal(clock.Now()) {
+		t.Fatalf("snapshot time=%v now=%v", snap.Time, clock.Now())
+	}
+}
+
+// waitFor wait background goroutine until cond true
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("wait timeout")
+}
+
+func TestRingByteCache(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	cache := NewRingByteCache(1000, SetRingShards(1), SetRingClock(clock))
+	// entry size = header 22 + key 2 + value 78 = 102, 9 entries fit in 1000 bytes
+	val := bytes.Repeat([]byte("v"), 78)
+	for i := 10; i < 20; i++ {
+		if err := cache.Set(ctx, strconv.Itoa(i), val, 0); err != nil {
+			t.Fatalf("Set err=%+v", err)
+		}
+	}
+	// fifo, the oldest is evicted
+	if _, err := cache.Get(ctx, "10"); !cache.IsErrNotFound(err) {
+		t.Fatalf("Get should be evicted, err=%+v", err)
+	}
+	for i := 11; i < 20; i++ {
+		if valIf, err := cache.Get(ctx, strconv.Itoa(i)); err != nil || !bytes.Equal(valIf.([]byte), val) {
+			t.Fatalf("Get key=%d val=%v err=%+v", i, valIf, err)
+		}
+	}
+	if stat := cache.(CacheStatIf).Stat(); stat.Len != 9 || stat.Bytes != 9*102 || stat.Evictions != 1 || stat.Capacity != 1000 {
+		t.Fatalf("stat=%+v", stat)
+	}
+	if err := cache.Set(ctx, "big", make([]byte, 1000), 0); err == nil {
+		t.Fatalf("Set entry larger than ring should fail")
+	}
+
+	cache.Set(ctx, "ttl", "v", 1)
+	clock.Advance(time.Second)
+	if _, err := cache.Get(ctx, "ttl"); err != nil {
+		t.Fatalf("Get err=%+v", err)
+	}
+	clock.Advance(time.Millisecond)
+	if _, err := cache.Get(ctx, "ttl"); !cache.IsErrNotFound(err) {
+		t.Fatalf("Get should expire, err=%+v", err)
+	}
+	if stat := cache.(CacheStatIf).Stat(); stat.Expirations != 1 {
+		t.Fatalf("stat=%+v", stat)
+	}
+}
+
+func TestRingByteCacheEvict(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	cache := NewRingByteCache(1000, SetRingShards(1), SetRingClock(clock)).(*RingByteCache)
+	reasons := map[string]EvictReason{}
+	values := map[string]string{}
+	cache.AddEvictFunc(func(strKey string, valIf interface{}, reason EvictReason) {
+		reasons[strKey] = reason
+		values[strKey] = string(valIf.([]byte))
+	})
+	cache.Set(ctx, "replaced", "old", 0)
+	cache.Set(ctx, "replaced", "new", 0)
+	cache.Set(ctx, "deleted", "v", 0)
+	cache.Del(ctx, "deleted")
+	cache.SetWithExpire(ctx, "expired", "v", ExpireOpt{TTL: time.Millisecond})
+	clock.Advance(2 * time.Millisecond)
+	cache.Get(ctx, "expired")
+	if reasons["replaced"] != EvictReplaced || values["replaced"] != "old" || reasons["deleted"] != EvictDeleted || reasons["expired"] != EvictExpired {
+		t.Fatalf("reasons=%+v values=%+v", reasons, values)
+	}
+	if err := cache.SetWithExpire(ctx, "idle", "v", ExpireOpt{Idle: time.Second}); err != ErrNotSupport {
+		t.Fatalf("SetWithExpire idle err=%+v", err)
+	}
+
+	// pinned entry survives fifo eviction
+	if kept, _ := cache.AddPinned(ctx, "pin", "first"); string(kept.([]byte)) != "first" {
+		t.Fatalf("AddPinned kept=%v", kept)
+	}
+	if kept, _ := cache.AddPinned(ctx, "pin", "second"); string(kept.([]byte)) != "first" {
+		t.Fatalf("AddPinned kept=%v", kept)
+	}
+	val := bytes.Repeat([]byte("v"), 78)
+	for i := 10; i < 30; i++ {
+		cache.Set(ctx, strconv.Itoa(i), val, 0)
+	}
+	if reasons["replaced"] != EvictCapacity || reasons["10"] != EvictCapacity || values["10"] != string(val) {
+		t.Fatalf("reasons=%+v", reasons)
+	}
+	if valIf, err := cache.Get(ctx, "pin"); err != nil || string(valIf.([]byte)) != "first" {
+		t.Fatalf("Get pinned val=%v err=%+v", valIf, err)
+	}
+
+	// GetInto reuses buffer
+	buf := make([]byte, 0, 128)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = cache.GetInto(ctx, "29", buf[:0])
+	})
+	if allocs != 0 || !bytes.Equal(buf, val) {
+		t.Fatalf("GetInto allocs=%v val=%s", allocs, buf)
+	}
+}
+
+func TestRingByteCacheEvictCount(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	cache := NewRingByteCache(1000, SetRingShards(1)).(*RingByteCache)
+	var nsCnt, allCnt int64
+	cache.AddEvictCountFunc("ns:", func(reason EvictReason) {
+		if reason == EvictCapacity {
+			nsCnt++
+		}
+	})
+	cache.AddEvictCountFunc("", func(reason EvictReason) {
+		allCnt++
+	})
+	keys := make([]string, 40)
+	for i := range keys {
+		keys[i] = "other" + strconv.Itoa(i)
+		if i%2 == 0 {
+			keys[i] = "ns:" + strconv.Itoa(i)
+		}
+	}
+	val := bytes.Repeat([]byte("v"), 78)
+	i := 0
+	// evictions are counted without copying keys or values
+	allocs := testing.AllocsPerRun(100, func() {
+		cache.Set(ctx, keys[i%len(keys)], val, 0)
+		i++
+	})
+	if allocs != 0 || nsCnt == 0 || allCnt <= nsCnt || atomic.LoadInt32(&cache.hasEvict) != 0 {
+		t.Fatalf("allocs=%v nsCnt=%d allCnt=%d", allocs, nsCnt, allCnt)
+	}
+
+	// ICache counts evictions through counter if no EvictFunc is set
+	ring := NewRingByteCache(1000, SetRingShards(1)).(*RingByteCache)
+	ic, err := NewICache(SetCache(ring), SetGetter(GetterIfFunc(func(ctx context.Context, strKey string, dest SinkIf) error {
+		return dest.SetBytes(val)
+	})), SetNamespace("ns", 0))
+	if err != nil {
+		t.Fatalf("NewICache err=%+v", err)
+	}
+	var got []byte
+	for _, strKey := range keys {
+		ic.Get(ctx, strKey, ByteSink(&got))
+	}
+	if snap := ic.Snapshot(); snap.EvictCapacityCnt == 0 || snap.EvictCapacityCnt != snap.Backend.Evictions || atomic.LoadInt32(&ring.hasEvict) != 0 {
+		t.Fatalf("snap=%+v", snap)
+	}
+	// EvictFunc needs copies
+	ic, _ = NewICache(SetCache(ring), SetGetter(GetterIfFunc(getter)), SetEvictFunc(func(string, interface{}, EvictReason) {}))
+	if atomic.LoadInt32(&ring.hasEvict) != 1 {
+		t.Fatalf("hasEvict=%d", ring.hasEvict)
+	}
+}
+
+func TestRingByteCacheWrap(t *testing.T) {
+	// random sizes wrap entries around ring end, compare with map of the latest values
+	ctx := context.Background()
+	cache := NewRingByteCache(4096, SetRingShards(2))
+	rnd := rand.New(rand.NewSource(1))
+	latest := make(map[string][]byte)
+	for i := 0; i < 20000; i++ {
+		strKey := "key" + strconv.Itoa(rnd.Intn(100))
+		switch rnd.Intn(4) {
+		case 0:
+			cache.Del(ctx, strKey)
+			delete(latest, strKey)
+		default:
+			val := bytes.Repeat([]byte{byte(i)}, rnd.Intn(300))
+			if err := cache.Set(ctx, strKey, val, 0); err != nil {
+				t.Fatalf("Set err=%+v", err)
+			}
+			latest[strKey] = val
+		}
+		valIf, err := cache.Get(ctx, strKey)
+		if err == nil && !bytes.Equal(valIf.([]byte), latest[strKey]) {
+			t.Fatalf("idx=%d key=%s val=%v want=%v", i, strKey, valIf, latest[strKey])
+		}
+		if err != nil && !cache.IsErrNotFound(err) {
+			t.Fatalf("Get err=%+v", err)
+		}
+	}
+	hits := 0
+	for strKey, val := range latest {
+		if valIf, err := cache.Get(ctx, strKey); err == nil {
+			hits++
+			if !bytes.Equal(valIf.([]byte), val) {
+				t.Fatalf("key=%s val=%v want=%v", strKey, valIf, val)
+			}
+		}
+	}
+	if hits == 0 {
+		t.Fatalf("no hits")
+	}
+}
+
+const benchEntries = 1000000
+
+func benchValue(i int) []byte {
+	return []byte("value-" + strconv.Itoa(i) + "-0123456789abcdef0123456789abcdef")
+}
+
+func BenchmarkRingByteCacheSet(b *testing.B) {
+	ctx := context.Background()
+	val := benchValue(0)
+	b.Run("Cache", func(b *testing.B) {
+		cache := NewRingByteCache(256 << 20)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			cache.Set(ctx, strconv.Itoa(i%benchEntries), val, 0)
+		}
+	})
+	b.Run("ICache", func(b *testing.B) {
+		// every Get misses and sets, small ring evicts on every set
+		ic := newBenchICache(NewRingByteCache(1<<20), val)
+		var got []byte
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			ic.Get(ctx, strconv.Itoa(i), ByteSink(&got))
+		}
+	})
+}
+
+// newBenchICache ICache loading val for every key
+func newBenchICache(cache CacheIf, val []byte) *ICache {
+	ic, _ := NewICache(SetCache(cache), SetGetter(GetterIfFunc(func(ctx context.Context, strKey string, dest SinkIf) error {
+		return dest.SetBytes(val)
+	})))
+	return ic
+}
+
+func BenchmarkLRUByteCacheSet(b *testing.B) {
+	ctx := context.Background()
+	cache := NewLRUByteCache(benchEntries)
+	val := benchValue(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Set(ctx, strconv.Itoa(i%benchEntries), val, 0)
+	}
+}
+
+func BenchmarkRingByteCacheGet(b *testing.B) {
+	ctx := context.Background()
+	cache := NewRingByteCache(256 << 20)
+	benchGet(b, ctx, cache)
+}
+
+func BenchmarkLRUByteCacheGet(b *testing.B) {
+	ctx := context.Background()
+	cache := NewLRUByteCache(benchEntries)
+	benchGet(b, ctx, cache)
+}
+
+func benchGet(b *testing.B, ctx context.Context, cache CacheIf) {
+	keys := make([]string, 100000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		cache.Set(ctx, keys[i], benchValue(i), 0)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Get(ctx, keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+// BenchmarkICacheGetParallel ICache hits from all cores, stats are committed on every Get
+func BenchmarkICacheGetParallel(b *testing.B) {
+	ctx := context.Background()
+	ic := newBenchICache(NewRingByteCache(256<<20), benchValue(0))
+	keys := make([]string, 100000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		var val []byte
+		ic.Get(ctx, keys[i], ByteSink(&val))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var val []byte
+		i := 0
+		for pb.Next() {
+			ic.Get(ctx, keys[i%len(keys)], ByteSink(&val))
+			i++
+		}
+	})
+}
+
+// BenchmarkGCPause full GC time with benchEntries entries in cache, ns/op is one GC cycle
+func BenchmarkGCPause(b *testing.B) {
+	ctx := context.Background()
+	for _, bc := range []struct {
+		name   string
+		cache  func() CacheIf
+		icache bool // fill through ICache
+	}{
+		{"LRUByteCache", func() CacheIf { return NewLRUByteCache(benchEntries) }, false},
+		{"RingByteCache", func() CacheIf { return NewRingByteCache(256 << 20) }, false},
+		{"ICache/LRUByteCache", func() CacheIf { return NewLRUByteCache(benchEntries) }, true},
+		{"ICache/RingByteCache", func() CacheIf { return NewRingByteCache(256 << 20) }, true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cache := bc.cache()
+			if bc.icache {
+				ic := newBenchICache(cache, benchValue(0))
+				var got []byte
+				// ICache registers its evict counter on the cache
+				for i := 0; i < benchEntries; i++ {
+					ic.Get(ctx, strconv.Itoa(i), ByteSink(&got))
+				}
+			} else {
+				for i := 0; i < benchEntries; i++ {
+					cache.Set(ctx, strconv.Itoa(i), benchValue(i), 0)
+				}
+			}
+			runtime.GC()
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+			}
+			b.StopTimer()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "pause-ns/op")
+			b.ReportMetric(float64(after.HeapObjects), "heap-objects")
+			runtime.KeepAlive(cache)
+		})
+	}
+}
+
+func TestCompressCache(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	inner := NewLRUByteCache(100)
+	cache := NewCompressCache(inner, NewGzipCodec(gzip.BestSpeed), 64)
+	big := strings.Repeat(`{"name":"icache","tags":["a","b"]}`, 100)
+	cache.Set(ctx, "big", big, 0)
+	cache.Set(ctx, "small", "small", 0)
+	rnd := rand.New(rand.NewSource(1))
+	noise := make([]byte, 1000)
+	rnd.Read(noise)
+	cache.Set(ctx, "noise", noise, 0)
+
+	for strKey, want := range map[string]string{"big": big, "small": "small", "noise": string(noise)} {
+		valIf, err := cache.Get(ctx, strKey)
+		if err != nil || string(valIf.([]byte)) != want {
+			t.Fatalf("Get key=%s err=%+v", strKey, err)
+		}
+	}
+	stored, _ := inner.Get(ctx, "big")
+	if stored.([]byte)[0] != CodecGzip || len(stored.([]byte)) > len(big)/10 {
+		t.Fatalf("stored header=%d len=%d", stored.([]byte)[0], len(stored.([]byte)))
+	}
+	if stored, _ := inner.Get(ctx, "noise"); stored.([]byte)[0] != CodecRaw {
+		t.Fatalf("incompressible value should be stored raw")
+	}
+	stat := cache.CompressStat()
+	if stat.SetCnt != 3 || stat.CompressedCnt != 1 || stat.IncompressibleCnt != 1 || stat.RawBytes != int64(len(big)+5+1000) {
+		t.Fatalf("stat=%+v", stat)
+	}
+	if ratio := stat.Ratio(); ratio <= 0 || ratio >= 0.5 {
+		t.Fatalf("ratio=%v", ratio)
+	}
+
+	// switch codec, values written by gzip are still readable
+	cache2 := NewCompressCache(inner, NewFlateCodec(flate.DefaultCompression), 64, NewGzipCodec(gzip.BestSpeed))
+	if valIf, err := cache2.Get(ctx, "big"); err != nil || string(valIf.([]byte)) != big {
+		t.Fatalf("Get err=%+v", err)
+	}
+	cache2.Set(ctx, "big", big, 0)
+	if stored, _ := inner.Get(ctx, "big"); stored.([]byte)[0] != CodecFlate {
+		t.Fatalf("stored header=%d", stored.([]byte)[0])
+	}
+	// cache without flate decoder
+	if _, err := cache.Get(ctx, "big"); err != ErrCodec {
+		t.Fatalf("Get err=%+v", err)
+	}
+	inner.Set(ctx, "corrupt", []byte{CodecFlate, 1, 2, 3}, 0)
+	if _, err := cache2.Get(ctx, "corrupt"); err != ErrCodec || cache2.CompressStat().DecodeErrCnt != 1 {
+		t.Fatalf("Get err=%+v", err)
+	}
+	// decompression bomb
+	bomb, _ := NewFlateCodec(flate.BestSpeed).Encode(make([]byte, maxDecodeSize+1))
+	inner.Set(ctx, "bomb", append([]byte{CodecFlate}, bomb...), 0)
+	if _, err := cache2.Get(ctx, "bomb"); err != ErrCodec || cache2.CompressStat().DecodeErrCnt != 2 {
+		t.Fatalf("Get err=%+v", err)
+	}
+	// raw value is a copy of stored value
+	valIf, _ := cache.Get(ctx, "small")
+	valIf.([]byte)[0] = 'S'
+	if valIf, _ := cache.Get(ctx, "small"); string(valIf.([]byte)) != "small" {
+		t.Fatalf("Get val=%s", valIf)
+	}
+
+	// compression stats in snapshot and prometheus
+	reg := NewRegistry()
+	ic, _ := NewICache(SetCache(cache), SetGetter(GetterIfFunc(getter)))
+	reg.Register("compress", ic)
+	var val string
+	if err := ic.Get(ctx, "stringKey", StringSink(&val)); err != nil || val != "string val" {
+		t.Fatalf("Get val=%s err=%+v", val, err)
+	}
+	if snap := ic.Snapshot(); !snap.HasCompress || snap.Compress.SetCnt != 4 {
+		t.Fatalf("snap=%+v", snap)
+	}
+	rec := httptest.NewRecorder()
+	NewPromHandler(reg).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `icache_compress_values_total{cache="compress"} 4`) {
+		t.Fatalf("metrics=%s", rec.Body.String())
+	}
+}
+
+func TestCompressCacheForward(t *testing.T) {
+	ctx := context.Background()
+	inner := NewLRUByteCache(2)
+	cache := NewCompressCache(inner, NewFlateCodec(flate.BestSpeed), 16)
+	evicted := map[string]string{}
+	ic, err := NewICache(
+		SetCache(cache),
+		SetGetter(GetterIfFunc(func(ctx context.Context, strKey string, dest SinkIf) error {
+			return dest.SetString(strings.Repeat(strKey, 10))
+		})),
+		SetEvictFunc(func(strKey string, valIf interface{}, reason EvictReason) {
+			evicted[strKey] = string(valIf.([]byte))
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	var val string
+	for _, strKey := range []string{"a", "b", "c"} {
+		ic.Get(ctx, strKey, StringSink(&val))
+	}
+	if evicted["a"] != strings.Repeat("a", 10) {
+		t.Fatalf("evicted=%+v", evicted)
+	}
+	if snap := ic.Snapshot(); !snap.HasBackend || snap.Backend.Len != 2 || snap.EvictCapacityCnt != 1 || !snap.HasCompress {
+		t.Fatalf("snap=%+v", snap)
+	}
+	var buf bytes.Buffer
+	if cnt, err := ic.Dump(ctx, &buf); err != nil || cnt != 2 {
+		t.Fatalf("Dump cnt=%d err=%+v", cnt, err)
+	}
+	sr, _ := snapshot.NewReader(&buf)
+	if rec, err := sr.Read(); err != nil || string(rec.Value) != strings.Repeat(rec.Key, 10) {
+		t.Fatalf("Read rec=%+v err=%+v", rec, err)
+	}
+
+	// features wrapped cache can not support
+	plain := NewCompressCache(struct{ CacheIf }{NewLRUByteCache(2)}, NewFlateCodec(flate.BestSpeed), 16)
+	if _, err := NewICache(SetCache(plain), SetGetter(GetterIfFunc(getter)), SetEvictFunc(func(string, interface{}, EvictReason) {})); err != ErrNotSupport {
+		t.Fatalf("NewICache err=%+v", err)
+	}
+	if _, err := NewICache(SetCache(plain), SetGetter(GetterIfFunc(getter)), SetIdleTTL(time.Hour)); err != ErrNotSupport {
+		t.Fatalf("NewICache err=%+v", err)
+	}
+	if err := plain.SetWithExpire(ctx, "k", "v", ExpireOpt{Idle: time.Hour}); err != ErrNotSupport {
+		t.Fatalf("SetWithExpire err=%+v", err)
+	}
+	// namespace and Peek fall back when wrapped cache can not pin or peek
+	icNs, err := NewICache(SetCache(plain), SetGetter(GetterIfFunc(getter)), SetNamespace("ns", 0))
+	if err != nil {
+		t.Fatalf("NewICache err=%+v", err)
+	}
+	ctx = context.WithValue(ctx, "testing", t)
+	if err := icNs.Get(ctx, "stringKey", StringSink(&val)); err != nil || val != "string val" {
+		t.Fatalf("Get val=%s err=%+v", val, err)
+	}
+	if err := icNs.BumpNamespace(ctx); err != nil {
+		t.Fatalf("BumpNamespace err=%+v", err)
+	}
+	if err := icNs.Get(ctx, "stringKey", StringSink(&val)); err != nil {
+		t.Fatalf("Get err=%+v", err)
+	}
+	if valIf, ttl, err := icNs.Peek(ctx, "stringKey"); err != nil || ttl != -1 || string(valIf.([]byte)) != val {
+		t.Fatalf("Peek val=%v ttl=%s err=%+v", valIf, ttl, err)
+	}
+	ic, _ = NewICache(SetCache(plain), SetGetter(GetterIfFunc(getter)))
+	if snap := ic.Snapshot(); snap.HasBackend || !snap.HasCompress {
+		t.Fatalf("snap=%+v", snap)
+	}
+	if _, err := ic.Dump(ctx, &buf); err != ErrNotSupport {
+		t.Fatalf("Dump err=%+v", err)
+	}
+}
+
+func TestEncryptCache(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "testing", t)
+	keyRing, err := NewKeyRing(1, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("NewKeyRing err=%+v", err)
+	}
+	if err := keyRing.Add(2, []byte("short")); err == nil {
+		t.Fatalf("Add invalid key should fail")
+	}
+	inner := NewLRUByteCache(100)
+	cache := NewEncryptCache(inner, keyRing)
+	cache.Set(ctx, "pii", "phone 13800000000", 0)
+	if valIf, err := cache.Get(ctx, "pii"); err != nil || string(valIf.([]byte)) != "phone 13800000000" {
+		t.Fatalf("Get val=%v err=%+v", valIf, err)
+	}
+	stored, _ := inner.Get(ctx, "pii")
+	if bytes.Contains(stored.([]byte), []byte("13800000000")) {
+		t.Fatalf("stored value is plaintext")
+	}
+
+	// tampered value is a miss
+	tampered := append([]byte(nil), stored.([]byte)...)
+	tampered[len(tampered)-1] ^= 1
+	inner.Set(ctx, "tampered", tampered, 0)
+	if _, err := cache.Get(ctx, "tampered"); err != ErrDecrypt || !cache.IsErrNotFound(err) {
+		t.Fatalf("Get tampered err=%+v", err)
+	}
+	// value moved to other key is a miss
+	inner.Set(ctx, "moved", stored, 0)
+	if _, err := cache.Get(ctx, "moved"); !cache.IsErrNotFound(err) {
+		t.Fatalf("Get moved err=%+v", err)
+	}
+	inner.Set(ctx, "plain", "plain", 0)
+	if _, err := cache.Get(ctx, "plain"); !cache.IsErrNotFound(err) {
+		t.Fatalf("Get plain err=%+v", err)
+	}
+	if stat := cache.EncryptStat(); stat.EncryptCnt != 1 || stat.DecryptCnt != 1 || stat.DecryptErrCnt != 3 {
+		t.Fatalf("stat=%+v", stat)
+	}
+
+	// rotation, old values stay readable until old key is removed
+	keyRing.Add(2, bytes.Repeat([]byte{2}, 16))
+	if err := keyRing.Rotate(2); err != nil {
+		t.Fatalf("Rotate err=%+v", err)
+	}
+	cache.Set(ctx, "new", "new", 0)
+	if stored, _ := inner.Get(ctx, "new"); binary.BigEndian.Uint32(stored.([]byte)[1:]) != 2 {
+		t.Fatalf("new value key id should be 2")
+	}
+	if _, err := cache.Get(ctx, "pii"); err != nil {
+		t.Fatalf("Get old key value err=%+v", err)
+	}
+	if err := keyRing.Remove(2); err == nil {
+		t.Fatalf("Remove current key should fail")
+	}
+	keyRing.Remove(1)
+	if _, err := cache.Get(ctx, "pii"); !cache.IsErrNotFound(err) {
+		t.Fatalf("Get removed key value err=%+v", err)
+	}
+
+	// ICache reloads tampered value from source
+	ic, _ := NewICache(SetCache(cache), SetGetter(GetterIfFunc(getter)))
+	inner.Set(ctx, "stringKey", tampered, 0)
+	var val string
+	if err := ic.Get(ctx, "stringKey", StringSink(&val)); err != nil || val != "string val" {
+		t.Fatalf("Get val=%s err=%+v", val, err)
+	}
+	if err := ic.Get(ctx, "stringKey", StringSink(&val)); err != nil || val != "string val" {
+		t.Fatalf("Get val=%s err=%+v", val, err)
+	}
+	if stat := ic.GetStat(); stat.ErrCnt != 0 || stat.SourceCnt != 1 || stat.HitCnt != 1 {
+		t.Fatalf("stat=%+v", stat)
+	}
+}
+
+func TestEncryptCacheForward(t *testing.T) {
+	ctx := context.Background()
+	keyRing, _ := NewKeyRing(1, bytes.Repeat([]byte{1}, 32))
+	// encrypt over compress, compression of ciphertext only shows the forwarding
+	inner := NewLRUByteCache(2)
+	cache := NewEncryptCache(NewCompressCache(inner, NewFlateCodec(flate.BestSpeed), 1024), keyRing)
+	evicted := map[string]string{}
+	ic, err := NewICache(
+		SetCache(cache),
+		SetGetter(GetterIfFunc(func(ctx context.Context, strKey string, dest SinkIf) error {
+			return dest.SetString("secret " + strKey)
+		})),
+		SetNamespace("enc", time.Minute),
+		SetEvictFunc(func(strKey string, valIf interface{}, reason EvictReason) {
+			evicted[strKey] = string(valIf.([]byte))
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	var val string
+	for _, strKey := range []string{"a", "b", "c"} {
+		if err := ic.Get(ctx, strKey, StringSink(&val)); err != nil || val != "secret "+strKey {
+			t.Fatalf("Get val=%s err=%+v", val, err)
+		}
+	}
+	if evicted["a"] != "secret a" {
+		t.Fatalf("evicted=%+v", evicted)
+	}
+	snap := ic.Snapshot()
+	if !snap.HasBackend || snap.Backend.Len != 3 || !snap.HasCompress || snap.Compress.SetCnt != 4 {
+		t.Fatalf("snap=%+v", snap)
+	}
+	var buf bytes.Buffer
+	if _, err := cache.Dump(ctx, &buf); err != nil || bytes.Contains(buf.Bytes(), []byte("secret")) {
+		t.Fatalf("Dump err=%+v", err)
+	}
+
+	// shared remote cache without optional interfaces
+	remote := struct{ CacheIf }{NewLRUByteCache(10)}
+	icRemote, err := NewICache(
+		SetCache(NewEncryptCache(remote, keyRing)),
+		SetGetter(GetterIfFunc(func(ctx context.Context, strKey string, dest SinkIf) error {
+			return dest.SetString("secret " + strKey)
+		})),
+		SetNamespace("enc", 0),
+	)
+	if err != nil {
+		t.Fatalf("NewICache fail, err=%+v", err)
+	}
+	if err := icRemote.Get(ctx, "a", StringSink(&val)); err != nil || val != "secret a" {
+		t.Fatalf("Get val=%s err=%+v", val, err)
+	}
+	if valIf, ttl, err := icRemote.Peek(ctx, "a"); err != nil || ttl != -1 || string(valIf.([]byte)) != "secret a" {
+		t.Fatalf("Peek val=%v ttl=%s err=%+v", valIf, ttl, err)
+	}
+	if _, err := icRemote.DeletePrefix(ctx, ""); err != ErrNotSupport {
+		t.Fatalf("DeletePrefix err=%+v", err)
+	}
+	if err := icRemote.Purge(ctx); err != nil {
+		t.Fatalf("Purge err=%+v", err)
+	}
+}
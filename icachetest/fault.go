@@ -0,0 +1,243 @@
+package icachetest
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iglev/icache"
+)
+
+var (
+	// ErrInjected default injected error
+	ErrInjected = errors.New("icachetest: injected fault")
+	// ErrInjectedTimeout injected latency exceeds Fault.Timeout
+	ErrInjectedTimeout = errors.New("icachetest: injected timeout")
+)
+
+// FaultOp op bits a fault applies to
+type FaultOp int
+
+const (
+	// FaultGet CacheIf.Get and GetterIf.Get
+	FaultGet FaultOp = 1 << iota
+	// FaultSet CacheIf.Set
+	FaultSet
+	// FaultDel CacheIf.Del
+	FaultDel
+
+	// FaultAll all ops
+	FaultAll = FaultGet | FaultSet | FaultDel
+)
+
+// Fault fault rule, applied in order: hang, latency, error
+type Fault struct {
+	Pattern string  // path.Match key pattern, "" matches all keys
+	Ops     FaultOp // 0 means FaultAll
+
+	Latency     time.Duration                      // fixed latency
+	Jitter      time.Duration                      // extra uniform random latency in [0, Jitter)
+	LatencyFunc func(rnd *rand.Rand) time.Duration // custom latency distribution, overrides Latency and Jitter
+	Timeout     time.Duration                      // latency >= Timeout fails with ErrInjectedTimeout after Timeout, 0 no timeout
+
+	ErrRate  float64 // probability in [0, 1] of failing with Err
+	Err      error   // default ErrInjected
+	HangRate float64 // probability in [0, 1] of blocking until ctx done
+}
+
+func (f *Fault) match(op FaultOp, strKey string) bool {
+	if f.Ops != 0 && f.Ops&op == 0 {
+		return false
+	}
+	if f.Pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(f.Pattern, strKey)
+	return ok
+}
+
+// FaultStats injected fault counters
+type FaultStats struct {
+	Calls    int64 // calls through wrappers
+	Errors   int64 // injected errors, timeouts included
+	Timeouts int64 // injected timeouts
+	Hangs    int64 // hung calls
+	Delayed  int64 // calls with injected latency
+}
+
+// FaultInjector seeded fault source shared by wrappers,
+// sequential calls with the same seed get the same faults
+type FaultInjector struct {
+	mu     sync.Mutex
+	rnd    *rand.Rand
+	faults []Fault
+
+	stats FaultStats // atomic
+}
+
+// NewFaultInjector new fault injector, all matched faults apply to a call
+func NewFaultInjector(seed int64, faults ...Fault) *FaultInjector {
+	return &FaultInjector{
+		rnd:    rand.New(rand.NewSource(seed)),
+		faults: faults,
+	}
+}
+
+// SetFaults replace faults, e.g. recover the backend in the middle of a test
+func (fi *FaultInjector) SetFaults(faults ...Fault) {
+	fi.mu.Lock()
+	fi.faults = faults
+	fi.mu.Unlock()
+}
+
+// Stats injected fault counters
+func (fi *FaultInjector) Stats() FaultStats {
+	return FaultStats{
+		Calls:    atomic.LoadInt64(&fi.stats.Calls),
+		Errors:   atomic.LoadInt64(&fi.stats.Errors),
+		Timeouts: atomic.LoadInt64(&fi.stats.Timeouts),
+		Hangs:    atomic.LoadInt64(&fi.stats.Hangs),
+		Delayed:  atomic.LoadInt64(&fi.stats.Delayed),
+	}
+}
+
+// decision fault decision of one call
+type decision struct {
+	hang    bool
+	latency time.Duration
+	timeout time.Duration
+	err     error
+}
+
+// decide draw random numbers of matched faults under lock
+func (fi *FaultInjector) decide(op FaultOp, strKey string) decision {
+	var d decision
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	for i := range fi.faults {
+		f := &fi.faults[i]
+		if !f.match(op, strKey) {
+			continue
+		}
+		if f.HangRate > 0 && fi.rnd.Float64() < f.HangRate {
+			d.hang = true
+		}
+		latency := f.Latency
+		if f.LatencyFunc != nil {
+			latency = f.LatencyFunc(fi.rnd)
+		} else if f.Jitter > 0 {
+			latency += time.Duration(fi.rnd.Int63n(int64(f.Jitter)))
+		}
+		d.latency += latency
+		if f.Timeout > 0 && (d.timeout == 0 || f.Timeout < d.timeout) {
+			d.timeout = f.Timeout
+		}
+		if d.err == nil && f.ErrRate > 0 && fi.rnd.Float64() < f.ErrRate {
+			d.err = f.Err
+			if d.err == nil {
+				d.err = ErrInjected
+			}
+		}
+	}
+	return d
+}
+
+// inject apply faults to a call, return non-nil error to fail the call
+func (fi *FaultInjector) inject(ctx context.Context, op FaultOp, strKey string) error {
+	atomic.AddInt64(&fi.stats.Calls, 1)
+	d := fi.decide(op, strKey)
+	if d.hang {
+		atomic.AddInt64(&fi.stats.Hangs, 1)
+		atomic.AddInt64(&fi.stats.Errors, 1)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if d.latency > 0 {
+		atomic.AddInt64(&fi.stats.Delayed, 1)
+		timedOut := d.timeout > 0 && d.latency >= d.timeout
+		if timedOut {
+			d.latency = d.timeout
+		}
+		timer := time.NewTimer(d.latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			atomic.AddInt64(&fi.stats.Errors, 1)
+			return ctx.Err()
+		}
+		if timedOut {
+			atomic.AddInt64(&fi.stats.Timeouts, 1)
+			atomic.AddInt64(&fi.stats.Errors, 1)
+			return ErrInjectedTimeout
+		}
+	}
+	if d.err != nil {
+		atomic.AddInt64(&fi.stats.Errors, 1)
+	}
+	return d.err
+}
+
+// FaultCache CacheIf wrapper injecting faults before calling the wrapped cache,
+// optional interfaces of the wrapped cache are not exposed
+type FaultCache struct {
+	cache icache.CacheIf
+	fi    *FaultInjector
+}
+
+// NewFaultCache wrap cache with fault injector
+func NewFaultCache(cache icache.CacheIf, fi *FaultInjector) *FaultCache {
+	return &FaultCache{cache: cache, fi: fi}
+}
+
+// Get get
+func (c *FaultCache) Get(ctx context.Context, strKey string) (interface{}, error) {
+	if err := c.fi.inject(ctx, FaultGet, strKey); err != nil {
+		return nil, err
+	}
+	return c.cache.Get(ctx, strKey)
+}
+
+// Set set
+func (c *FaultCache) Set(ctx context.Context, strKey string, valIf interface{}, iTTL int32) error {
+	if err := c.fi.inject(ctx, FaultSet, strKey); err != nil {
+		return err
+	}
+	return c.cache.Set(ctx, strKey, valIf, iTTL)
+}
+
+// Del del
+func (c *FaultCache) Del(ctx context.Context, strKey string) error {
+	if err := c.fi.inject(ctx, FaultDel, strKey); err != nil {
+		return err
+	}
+	return c.cache.Del(ctx, strKey)
+}
+
+// IsErrNotFound injected errors are never not found
+func (c *FaultCache) IsErrNotFound(err error) bool {
+	return c.cache.IsErrNotFound(err)
+}
+
+// FaultGetter GetterIf wrapper injecting FaultGet faults before calling the wrapped getter
+type FaultGetter struct {
+	getter icache.GetterIf
+	fi     *FaultInjector
+}
+
+// NewFaultGetter wrap getter with fault injector
+func NewFaultGetter(getter icache.GetterIf, fi *FaultInjector) *FaultGetter {
+	return &FaultGetter{getter: getter, fi: fi}
+}
+
+// Get get
+func (g *FaultGetter) Get(ctx context.Context, strKey string, dest icache.SinkIf) error {
+	if err := g.fi.inject(ctx, FaultGet, strKey); err != nil {
+		return err
+	}
+	return g.getter.Get(ctx, strKey, dest)
+}
@@ -0,0 +1,434 @@
+// Package icachetest provides test helpers for icache.CacheIf implementations.
+//
+// Run the conformance suite from a backend's tests:
+//
+//	func TestMyCache(t *testing.T) {
+//		icachetest.RunCacheIfSuite(t, func(clock icache.Clock) icache.CacheIf {
+//			return NewMyCache(1024, clock)
+//		})
+//	}
+//
+// Run tests with -race to check concurrent safety.
+package icachetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iglev/icache"
+)
+
+const (
+	// MinCapacity the suite never holds more keys than this at once,
+	// factory must return caches that hold at least MinCapacity keys
+	MinCapacity = 256
+
+	concurrentWorkers = 8
+	concurrentKeys    = 64
+	concurrentOps     = 2000
+)
+
+// Factory return a new empty cache measuring ttl and idle timeout by clock,
+// called once for each sub test, the cache is closed after the sub test if it implements io.Closer
+type Factory func(clock icache.Clock) icache.CacheIf
+
+// RunCacheIfSuite run conformance tests against caches returned by factory.
+// Values are []byte, caches may return them as []byte or string.
+// Optional interfaces CacheExpireIf, CachePeekIf and CacheIterIf are tested when implemented
+// by the cache and every cache it wraps through icache.CacheUnwrapIf,
+// SetWithExpire may return icache.ErrNotSupport for idle timeout.
+// TTL tests advance a FakeClock passed to factory instead of sleeping.
+func RunCacheIfSuite(t *testing.T, factory Factory) {
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, c icache.CacheIf, clock *icache.FakeClock)
+	}{
+		{"GetNotFound", testGetNotFound},
+		{"SetGet", testSetGet},
+		{"Overwrite", testOverwrite},
+		{"Del", testDel},
+		{"IsErrNotFound", testIsErrNotFound},
+		{"ZeroTTL", testZeroTTL},
+		{"TTL", testTTL},
+		{"OverwriteTTL", testOverwriteTTL},
+		{"ExpireTTL", testExpireTTL},
+		{"ExpireIdle", testExpireIdle},
+		{"Peek", testPeek},
+		{"Iter", testIter},
+		{"Concurrent", testConcurrent},
+		{"ConcurrentSameKey", testConcurrentSameKey},
+	}
+	for _, tc := range cases {
+		fn := tc.fn
+		t.Run(tc.name, func(t *testing.T) {
+			clock := icache.NewFakeClock(time.Now())
+			c := factory(clock)
+			if closer, ok := c.(io.Closer); ok {
+				defer closer.Close()
+			}
+			fn(t, c, clock)
+		})
+	}
+}
+
+func testGetNotFound(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	_, err := c.Get(context.Background(), "not_exist")
+	if err == nil {
+		t.Fatalf("Get missing key should fail")
+	}
+	if !c.IsErrNotFound(err) {
+		t.Fatalf("Get missing key, IsErrNotFound(%+v) should be true", err)
+	}
+}
+
+func testSetGet(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	ctx := context.Background()
+	keys := []string{"k", "", "key with space", "中文", "a:b:c", string(bytes.Repeat([]byte("x"), 1024))}
+	for i, strKey := range keys {
+		if err := c.Set(ctx, strKey, value(i), 0); err != nil {
+			t.Fatalf("Set key=%q err=%+v", strKey, err)
+		}
+	}
+	for i, strKey := range keys {
+		expectValue(t, c, strKey, value(i))
+	}
+	if err := c.Set(ctx, "empty", []byte{}, 0); err != nil {
+		t.Fatalf("Set empty value err=%+v", err)
+	}
+	expectValue(t, c, "empty", []byte{})
+}
+
+func testOverwrite(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := c.Set(ctx, "k", value(i), 0); err != nil {
+			t.Fatalf("Set err=%+v", err)
+		}
+		expectValue(t, c, "k", value(i))
+	}
+	if err := c.Set(ctx, "k", []byte("short"), 0); err != nil {
+		t.Fatalf("Set err=%+v", err)
+	}
+	expectValue(t, c, "k", []byte("short"))
+}
+
+func testDel(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	ctx := context.Background()
+	c.Set(ctx, "k1", value(1), 0)
+	c.Set(ctx, "k2", value(2), 0)
+	if err := c.Del(ctx, "k1"); err != nil {
+		t.Fatalf("Del err=%+v", err)
+	}
+	expectNotFound(t, c, "k1")
+	expectValue(t, c, "k2", value(2))
+	if err := c.Del(ctx, "k1"); err != nil {
+		t.Fatalf("Del deleted key err=%+v", err)
+	}
+	if err := c.Del(ctx, "not_exist"); err != nil {
+		t.Fatalf("Del missing key err=%+v", err)
+	}
+	c.Set(ctx, "k1", value(3), 0)
+	expectValue(t, c, "k1", value(3))
+}
+
+func testIsErrNotFound(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	if c.IsErrNotFound(nil) {
+		t.Fatalf("IsErrNotFound(nil) should be false")
+	}
+	if c.IsErrNotFound(errors.New("other error")) {
+		t.Fatalf("IsErrNotFound(other error) should be false")
+	}
+}
+
+func testZeroTTL(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	c.Set(context.Background(), "k", value(1), 0)
+	clock.Advance(24 * time.Hour)
+	expectValue(t, c, "k", value(1))
+}
+
+func testTTL(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	ctx := context.Background()
+	c.Set(ctx, "short", value(1), 1)
+	c.Set(ctx, "long", value(2), 60)
+	c.Set(ctx, "forever", value(3), 0)
+	expectValue(t, c, "short", value(1))
+	clock.Advance(1100 * time.Millisecond)
+	expectNotFound(t, c, "short")
+	expectValue(t, c, "long", value(2))
+	expectValue(t, c, "forever", value(3))
+}
+
+func testOverwriteTTL(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	ctx := context.Background()
+	// overwrite replaces ttl in both directions
+	c.Set(ctx, "to_forever", value(1), 1)
+	c.Set(ctx, "to_forever", value(2), 0)
+	c.Set(ctx, "to_short", value(3), 0)
+	c.Set(ctx, "to_short", value(4), 1)
+	clock.Advance(1100 * time.Millisecond)
+	expectValue(t, c, "to_forever", value(2))
+	expectNotFound(t, c, "to_short")
+}
+
+func testExpireTTL(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	ec, ok := c.(icache.CacheExpireIf)
+	if !ok || !supports(c, isExpireCache) {
+		t.Skip("CacheExpireIf not implemented")
+	}
+	ctx := context.Background()
+	if err := ec.SetWithExpire(ctx, "short", value(1), icache.ExpireOpt{TTL: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("SetWithExpire err=%+v", err)
+	}
+	ec.SetWithExpire(ctx, "forever", value(2), icache.ExpireOpt{})
+	expectValue(t, c, "short", value(1))
+	clock.Advance(49 * time.Millisecond)
+	expectValue(t, c, "short", value(1))
+	clock.Advance(2 * time.Millisecond)
+	expectNotFound(t, c, "short")
+	expectValue(t, c, "forever", value(2))
+}
+
+func testExpireIdle(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	ec, ok := c.(icache.CacheExpireIf)
+	if !ok || !supports(c, isExpireCache) {
+		t.Skip("CacheExpireIf not implemented")
+	}
+	ctx := context.Background()
+	if err := ec.SetWithExpire(ctx, "touched", value(1), icache.ExpireOpt{Idle: 100 * time.Millisecond}); err == icache.ErrNotSupport {
+		t.Skip("idle timeout not supported")
+	}
+	ec.SetWithExpire(ctx, "idle", value(2), icache.ExpireOpt{Idle: 100 * time.Millisecond})
+	ec.SetWithExpire(ctx, "ttl", value(3), icache.ExpireOpt{TTL: 150 * time.Millisecond, Idle: time.Hour})
+	for i := 0; i < 4; i++ {
+		clock.Advance(50 * time.Millisecond)
+		expectValue(t, c, "touched", value(1))
+	}
+	expectNotFound(t, c, "idle")
+	// absolute ttl wins over idle timeout
+	expectNotFound(t, c, "ttl")
+}
+
+func testPeek(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	pc, ok := c.(icache.CachePeekIf)
+	if !ok || !supports(c, isPeekCache) {
+		t.Skip("CachePeekIf not implemented")
+	}
+	ctx := context.Background()
+	if _, _, err := pc.Peek(ctx, "not_exist"); !c.IsErrNotFound(err) {
+		t.Fatalf("Peek missing key, err=%+v", err)
+	}
+	c.Set(ctx, "forever", value(1), 0)
+	c.Set(ctx, "ttl", value(2), 60)
+	valIf, ttl, err := pc.Peek(ctx, "forever")
+	if err != nil || ttl != 0 {
+		t.Fatalf("Peek forever ttl=%s err=%+v", ttl, err)
+	}
+	checkValue(t, "forever", valIf, value(1))
+	valIf, ttl, err = pc.Peek(ctx, "ttl")
+	if err != nil || ttl <= 59*time.Second || ttl > 60*time.Second {
+		t.Fatalf("Peek ttl ttl=%s err=%+v", ttl, err)
+	}
+	checkValue(t, "ttl", valIf, value(2))
+}
+
+func testIter(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	ic, ok := c.(icache.CacheIterIf)
+	if !ok || !supports(c, isIterCache) {
+		t.Skip("CacheIterIf not implemented")
+	}
+	ctx := context.Background()
+	var expect []string
+	for i := 0; i < 10; i++ {
+		strKey := fmt.Sprintf("user:%d", i)
+		c.Set(ctx, strKey, value(i), 0)
+		expect = append(expect, strKey)
+	}
+	c.Set(ctx, "item:1", value(1), 0)
+	c.Set(ctx, "item:2", value(2), 0)
+	expect = append(expect, "item:1", "item:2")
+
+	var keys []string
+	if err := ic.Range(ctx, func(strKey string) bool {
+		keys = append(keys, strKey)
+		return true
+	}); err != nil {
+		t.Fatalf("Range err=%+v", err)
+	}
+	sort.Strings(keys)
+	sort.Strings(expect)
+	if fmt.Sprint(keys) != fmt.Sprint(expect) {
+		t.Fatalf("Range keys=%v, expect=%v", keys, expect)
+	}
+
+	cnt := 0
+	ic.Range(ctx, func(strKey string) bool {
+		cnt++
+		return cnt < 3
+	})
+	if cnt != 3 {
+		t.Fatalf("Range should stop when fn return false, cnt=%d", cnt)
+	}
+
+	iDel, err := ic.DelPrefix(ctx, "user:")
+	if err != nil || iDel != 10 {
+		t.Fatalf("DelPrefix cnt=%d err=%+v", iDel, err)
+	}
+	expectNotFound(t, c, "user:3")
+	expectValue(t, c, "item:1", value(1))
+	if iDel, err = ic.DelPrefix(ctx, "user:"); err != nil || iDel != 0 {
+		t.Fatalf("DelPrefix again cnt=%d err=%+v", iDel, err)
+	}
+}
+
+// testConcurrent mixed ops on shared keys, a hit must return a value set for that key
+func testConcurrent(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errCh := make(chan error, concurrentWorkers)
+	for w := 0; w < concurrentWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < concurrentOps; i++ {
+				strKey := strconv.Itoa((w*7 + i) % concurrentKeys)
+				switch i % 4 {
+				case 0, 1:
+					if err := c.Set(ctx, strKey, []byte(strKey+":"+strconv.Itoa(w)), 0); err != nil {
+						errCh <- fmt.Errorf("Set key=%s err=%+v", strKey, err)
+						return
+					}
+				case 2:
+					valIf, err := c.Get(ctx, strKey)
+					if err != nil {
+						if !c.IsErrNotFound(err) {
+							errCh <- fmt.Errorf("Get key=%s err=%+v", strKey, err)
+							return
+						}
+						continue
+					}
+					val, ok := toBytes(valIf)
+					if !ok || !bytes.HasPrefix(val, []byte(strKey+":")) {
+						errCh <- fmt.Errorf("Get key=%s got value of other key %v", strKey, valIf)
+						return
+					}
+				case 3:
+					if err := c.Del(ctx, strKey); err != nil {
+						errCh <- fmt.Errorf("Del key=%s err=%+v", strKey, err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Error(err)
+	}
+}
+
+// testConcurrentSameKey concurrent overwrites of one key, last value must be one of the written values
+func testConcurrentSameKey(t *testing.T, c icache.CacheIf, clock *icache.FakeClock) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for w := 0; w < concurrentWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < concurrentOps/10; i++ {
+				c.Set(ctx, "k", value(w), 0)
+				c.Get(ctx, "k")
+			}
+		}(w)
+	}
+	wg.Wait()
+	valIf, err := c.Get(ctx, "k")
+	if err != nil {
+		t.Fatalf("Get err=%+v", err)
+	}
+	val, _ := toBytes(valIf)
+	for w := 0; w < concurrentWorkers; w++ {
+		if bytes.Equal(val, value(w)) {
+			return
+		}
+	}
+	t.Fatalf("Get value=%v not written by any worker", valIf)
+}
+
+// supports check every cache of wrapper chain, wrappers forward optional
+// interfaces that work only when the wrapped cache implements them
+func supports(c icache.CacheIf, check func(icache.CacheIf) bool) bool {
+	for {
+		if !check(c) {
+			return false
+		}
+		wrapper, ok := c.(icache.CacheUnwrapIf)
+		if !ok {
+			return true
+		}
+		c = wrapper.Unwrap()
+	}
+}
+
+func isExpireCache(c icache.CacheIf) bool {
+	_, ok := c.(icache.CacheExpireIf)
+	return ok
+}
+
+func isPeekCache(c icache.CacheIf) bool {
+	_, ok := c.(icache.CachePeekIf)
+	return ok
+}
+
+func isIterCache(c icache.CacheIf) bool {
+	_, ok := c.(icache.CacheIterIf)
+	return ok
+}
+
+func value(i int) []byte {
+	return []byte("value:" + strconv.Itoa(i))
+}
+
+func toBytes(valIf interface{}) ([]byte, bool) {
+	switch val := valIf.(type) {
+	case []byte:
+		return val, true
+	case string:
+		return []byte(val), true
+	default:
+		return nil, false
+	}
+}
+
+func checkValue(t *testing.T, strKey string, valIf interface{}, expect []byte) {
+	t.Helper()
+	val, ok := toBytes(valIf)
+	if !ok || !bytes.Equal(val, expect) {
+		t.Fatalf("key=%q value=%v, expect=%q", strKey, valIf, expect)
+	}
+}
+
+func expectValue(t *testing.T, c icache.CacheIf, strKey string, expect []byte) {
+	t.Helper()
+	valIf, err := c.Get(context.Background(), strKey)
+	if err != nil {
+		t.Fatalf("Get key=%q err=%+v", strKey, err)
+	}
+	checkValue(t, strKey, valIf, expect)
+}
+
+func expectNotFound(t *testing.T, c icache.CacheIf, strKey string) {
+	t.Helper()
+	valIf, err := c.Get(context.Background(), strKey)
+	if !c.IsErrNotFound(err) {
+		t.Fatalf("Get key=%q should not found, value=%v err=%+v", strKey, valIf, err)
+	}
+}
@@ -0,0 +1,78 @@
+package icache
+
+import (
+	"context"
+	"time"
+)
+
+// Op ICache op
+type Op int
+
+const (
+	// OpGet ICache.Get
+	OpGet Op = iota
+	// OpDelete ICache.Delete
+	OpDelete
+	// OpLoad load through flight group after cache miss
+	OpLoad
+	// OpSource GetterIf call
+	OpSource
+	// OpDeletePrefix ICache.DeletePrefix, Key is the prefix
+	OpDeletePrefix
+	// OpBumpNamespace ICache.BumpNamespace, Key is the namespace name
+	OpBumpNamespace
+)
+
+// String op name
+func (op Op) String() string {
+	switch op {
+	case OpGet:
+		return "get"
+	case OpDelete:
+		return "delete"
+	case OpLoad:
+		return "load"
+	case OpSource:
+		return "source"
+	case OpDeletePrefix:
+		return "delete_prefix"
+	case OpBumpNamespace:
+		return "bump_namespace"
+	default:
+		return "unknown"
+	}
+}
+
+// OpInfo op info, outcome fields are filled by inner handler
+type OpInfo struct {
+	Op  Op
+	Key string
+
+	Hit           bool          // OpGet: hit cache
+	Shared        bool          // OpGet, OpLoad: result shared from other goroutine in flight group
+	SourceLatency time.Duration // OpGet, OpLoad: source load latency, 0 if not loaded from source
+}
+
+// Handler op handler
+type Handler func(ctx context.Context, info *OpInfo) error
+
+// Interceptor op interceptor, call next to go on,
+// return without calling next to short-circuit, short-circuit Get should return an error
+type Interceptor func(ctx context.Context, info *OpInfo, next Handler) error
+
+// intercept run handler through interceptor chain, first interceptor is outermost
+func (ic *ICache) intercept(ctx context.Context, info *OpInfo, handler Handler) error {
+	if len(ic.interceptors) == 0 {
+		return handler(ctx, info)
+	}
+	return ic.chain(0, handler)(ctx, info)
+}
+
+func (ic *ICache) chain(idx int, handler Handler) Handler {
+	if idx >= len(ic.interceptors) {
+		return handler
+	}
+	return func(ctx context.Context, info *OpInfo) error {
+		return ic.interceptors[idx](ctx, info, ic.chain(idx+1, handler))
+	}
+}
@@ -0,0 +1,43 @@
+package icache
+
+import (
+	"sync"
+	"time"
+)
+
+// janitor background goroutine run fn every interval until Close
+type janitor struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func startJanitor(clock Clock, interval time.Duration, fn func()) *janitor {
+	j := &janitor{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	// create ticker before return, ticks of FakeClock advanced right after start are not lost
+	ticker := clock.NewTicker(interval)
+	go func() {
+		defer close(j.done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.Chan():
+				fn()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+	return j
+}
+
+// Close stop janitor and wait goroutine exit
+func (j *janitor) Close() {
+	j.once.Do(func() {
+		close(j.stop)
+	})
+	<-j.done
+}
@@ -0,0 +1,75 @@
+package icache
+
+import (
+	"context"
+	"io"
+)
+
+// CacheFlushIf optional cache interface, flush pending writes
+type CacheFlushIf interface {
+	Flush(context.Context) error
+}
+
+// acquire mark op in flight, return false if closed
+func (ic *ICache) acquire() bool {
+	ic.lifeMu.RLock()
+	defer ic.lifeMu.RUnlock()
+	if ic.closed {
+		return false
+	}
+	ic.inflight.Add(1)
+	return true
+}
+
+// release mark op done
+func (ic *ICache) release() {
+	ic.inflight.Done()
+}
+
+// Close stop accepting new ops and wait in-flight ops, then flush CacheIf if it implements
+// CacheFlushIf and close it if SetCloseCache(true). Return ctx.Err() without touching CacheIf
+// if ctx is done before in-flight ops finish, call Close again to go on waiting.
+// Close after flush returns its result. Later ops return ErrClosed.
+func (ic *ICache) Close(ctx context.Context) error {
+	ic.lifeMu.Lock()
+	if !ic.closed {
+		ic.closed = true
+		ic.drained = make(chan struct{})
+		go func(drained chan struct{}) {
+			ic.inflight.Wait()
+			close(drained)
+		}(ic.drained)
+	}
+	drained := ic.drained
+	ic.lifeMu.Unlock()
+
+	// wait in-flight ops
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	ic.finishOnce.Do(func() {
+		ic.closeErr = ic.finish(ctx)
+	})
+	return ic.closeErr
+}
+
+// finish flush and close CacheIf, no op in flight
+func (ic *ICache) finish(ctx context.Context) error {
+	var err error
+	if cacheSupports(ic.cache, isFlushCache) {
+		err = ic.cache.(CacheFlushIf).Flush(ctx)
+	}
+	if closer, ok := ic.cache.(io.Closer); ok && ic.closeCache {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func isFlushCache(cache CacheIf) bool {
+	_, ok := cache.(CacheFlushIf)
+	return ok
+}
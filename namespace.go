@@ -0,0 +1,156 @@
+package icache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	nsGenKeyPrefix = "__icache_ns_gen:"
+)
+
+// namespace key namespace, every cache key is prefixed with namespace generation,
+// bump generation invalidates the whole namespace without scanning
+type namespace struct {
+	name     string
+	localTTL time.Duration
+	clock    Clock
+
+	mu       sync.RWMutex
+	gen      int64
+	expireTs int64 // local gen expire ts, unix nano
+}
+
+func newNamespace(strName string, localTTL time.Duration) *namespace {
+	return &namespace{
+		name:     strName,
+		localTTL: localTTL,
+		clock:    realClock{},
+	}
+}
+
+// genKey gen key in CacheIf
+func (ns *namespace) genKey() string {
+	return nsGenKeyPrefix + ns.name
+}
+
+// cacheKey return key with namespace generation prefix
+func (ns *namespace) cacheKey(ctx context.Context, cache CacheIf, strKey string) (string, error) {
+	gen, err := ns.getGen(ctx, cache)
+	if err != nil {
+		return "", err
+	}
+	return ns.name + ":" + strconv.FormatInt(gen, 10) + ":" + strKey, nil
+}
+
+// userKey strip namespace generation prefix of cache key
+func (ns *namespace) userKey(strCacheKey string) (string, bool) {
+	if !strings.HasPrefix(strCacheKey, ns.name+":") {
+		return "", false
+	}
+	strRest := strCacheKey[len(ns.name)+1:]
+	idx := strings.IndexByte(strRest, ':')
+	if idx < 0 {
+		return "", false
+	}
+	return strRest[idx+1:], true
+}
+
+// getGen get generation, local cache first
+func (ns *namespace) getGen(ctx context.Context, cache CacheIf) (int64, error) {
+	now := ns.clock.Now().UnixNano()
+	ns.mu.RLock()
+	gen, expireTs := ns.gen, ns.expireTs
+	ns.mu.RUnlock()
+	if gen > 0 && now < expireTs {
+		return gen, nil
+	}
+
+	newGen, err := ns.loadGen(ctx, cache)
+	if err != nil {
+		if gen > 0 {
+			// CacheIf fail, use stale local gen
+			return gen, nil
+		}
+		return 0, err
+	}
+	ns.setLocal(newGen, now)
+	return newGen, nil
+}
+
+// loadGen load generation from CacheIf, init if not found.
+// Gen is pinned if the whole CacheIf chain implements CachePinIf, otherwise CacheIf must not evict it,
+// an evicted gen is re-initialized and invalidates the namespace
+func (ns *namespace) loadGen(ctx context.Context, cache CacheIf) (int64, error) {
+	valIf, err := cache.Get(ctx, ns.genKey())
+	if err == nil {
+		return parseGen(valIf)
+	}
+	if !cache.IsErrNotFound(err) {
+		return 0, err
+	}
+	strGen := strconv.FormatInt(ns.clock.Now().UnixNano(), 10)
+	if cacheSupports(cache, isPinCache) {
+		// first writer wins, concurrent init adopts its gen
+		valIf, err := cache.(CachePinIf).AddPinned(ctx, ns.genKey(), strGen)
+		if err != nil {
+			return 0, err
+		}
+		return parseGen(valIf)
+	}
+	if err := cache.Set(ctx, ns.genKey(), strGen, 0); err != nil {
+		return 0, err
+	}
+	// another process may init at the same time, adopt the winner
+	if valIf, err := cache.Get(ctx, ns.genKey()); err == nil {
+		return parseGen(valIf)
+	}
+	return parseGen(strGen)
+}
+
+// setGen store generation, pinned if the whole CacheIf chain implements CachePinIf
+func (ns *namespace) setGen(ctx context.Context, cache CacheIf, gen int64) error {
+	strGen := strconv.FormatInt(gen, 10)
+	if cacheSupports(cache, isPinCache) {
+		return cache.(CachePinIf).SetPinned(ctx, ns.genKey(), strGen)
+	}
+	return cache.Set(ctx, ns.genKey(), strGen, 0)
+}
+
+// bump bump generation
+func (ns *namespace) bump(ctx context.Context, cache CacheIf) (int64, error) {
+	now := ns.clock.Now().UnixNano()
+	gen := now
+	ns.mu.RLock()
+	if gen <= ns.gen {
+		gen = ns.gen + 1
+	}
+	ns.mu.RUnlock()
+	if err := ns.setGen(ctx, cache, gen); err != nil {
+		return 0, err
+	}
+	ns.setLocal(gen, now)
+	return gen, nil
+}
+
+func (ns *namespace) setLocal(gen int64, now int64) {
+	ns.mu.Lock()
+	ns.gen = gen
+	ns.expireTs = now + int64(ns.localTTL)
+	ns.mu.Unlock()
+}
+
+func parseGen(valIf interface{}) (int64, error) {
+	switch val := valIf.(type) {
+	case string:
+		return strconv.ParseInt(val, 10, 64)
+	case []byte:
+		return strconv.ParseInt(string(val), 10, 64)
+	default:
+		return 0, fmt.Errorf("invalid namespace gen type %T", valIf)
+	}
+}
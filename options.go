@@ -1,6 +1,10 @@
 package icache
 
-import "github.com/juju/ratelimit"
+import (
+	"time"
+
+	"github.com/juju/ratelimit"
+)
 
 // Option option
 type Option struct {
@@ -28,9 +32,88 @@ func SetCache(cache CacheIf) Option {
 	}}
 }
 
+// SetCloseCache ICache.Close closes CacheIf if it implements io.Closer, default false,
+// do not set it for CacheIf shared by several ICaches
+func SetCloseCache(bClose bool) Option {
+	return Option{func(ic *ICache) {
+		ic.closeCache = bClose
+	}}
+}
+
 // SetRateLimit set rate limit
 func SetRateLimit(iPerSecLimit int64) Option {
 	return Option{func(ic *ICache) {
 		ic.rateLimiter = ratelimit.NewBucketWithRate(1.0, iPerSecLimit)
 	}}
 }
+
+// SetNamespace set key namespace, generation is stored in CacheIf
+// and cached locally for localTTL
+func SetNamespace(strNs string, localTTL time.Duration) Option {
+	return Option{func(ic *ICache) {
+		ic.ns = newNamespace(strNs, localTTL)
+	}}
+}
+
+// SetTTLJitter add random jitter to ttl, ttl becomes ttl*(1+rand[0, fRatio)),
+// avoid keys loaded at the same time expire at the same time
+func SetTTLJitter(fRatio float64) Option {
+	return Option{func(ic *ICache) {
+		ic.ttlJitter = fRatio
+	}}
+}
+
+// SetIdleTTL set default idle timeout, entry expires after idle time without get,
+// SetSinkIdleTTL overrides it, CacheIf must implement CacheExpireIf, NewICache return ErrNotSupport otherwise
+func SetIdleTTL(idle time.Duration) Option {
+	return Option{func(ic *ICache) {
+		ic.idleTTL = idle
+	}}
+}
+
+// SetEvictFunc add evict callback with user key, CacheIf must implement CacheEvictIf,
+// NewICache return ErrNotSupport otherwise
+func SetEvictFunc(fn EvictFunc) Option {
+	return Option{func(ic *ICache) {
+		ic.evictFuncs = append(ic.evictFuncs, fn)
+	}}
+}
+
+// SetHotKeys track hot keys of Get and source load, keep iCapacity counters
+// in the sliding time window, see ICache.HotKeys and ICache.HotSourceKeys
+func SetHotKeys(iCapacity int, window time.Duration) Option {
+	return Option{func(ic *ICache) {
+		ic.hotKeys = newHotKeyTracker(iCapacity, window)
+		ic.hotSources = newHotKeyTracker(iCapacity, window)
+	}}
+}
+
+// SetTracer set tracer hook, default no-op
+func SetTracer(tracer Tracer) Option {
+	return Option{func(ic *ICache) {
+		ic.tracer = tracer
+	}}
+}
+
+// SetClock set clock of namespace local ttl, hot key windows and stats snapshot time,
+// latencies are always measured by time package, backends take their own clock
+func SetClock(clock Clock) Option {
+	return Option{func(ic *ICache) {
+		ic.clock = clock
+	}}
+}
+
+// SetInterceptors add interceptors of Get, Delete, DeletePrefix, BumpNamespace, load and source ops,
+// the first one is outermost
+func SetInterceptors(interceptors ...Interceptor) Option {
+	return Option{func(ic *ICache) {
+		ic.interceptors = append(ic.interceptors, interceptors...)
+	}}
+}
+
+// SetAccessLog log Get, Delete, DeletePrefix and BumpNamespace with sampling, it's the outermost interceptor
+func SetAccessLog(logger AccessLogger, opt AccessLogOpt) Option {
+	return Option{func(ic *ICache) {
+		ic.interceptors = append([]Interceptor{accessLogInterceptor(logger, opt)}, ic.interceptors...)
+	}}
+}
@@ -0,0 +1,175 @@
+package icache
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	promQuantiles = []float64{0.5, 0.9, 0.99, 0.999}
+)
+
+// promCounter counter metric
+type promCounter struct {
+	name string
+	help string
+	get  func(s *StatsSnapshot) int64
+}
+
+var promCounters = []promCounter{
+	{"icache_get_total", "Total ICache get ops.", func(s *StatsSnapshot) int64 { return s.GetCnt }},
+	{"icache_delete_total", "Total ICache delete ops.", func(s *StatsSnapshot) int64 { return s.DelCnt }},
+	{"icache_hit_total", "Total cache hits.", func(s *StatsSnapshot) int64 { return s.HitCnt }},
+	{"icache_miss_total", "Total cache misses.", func(s *StatsSnapshot) int64 { return s.MissCnt }},
+	{"icache_error_total", "Total cache errors.", func(s *StatsSnapshot) int64 { return s.ErrCnt }},
+	{"icache_source_total", "Total loads from source.", func(s *StatsSnapshot) int64 { return s.SourceCnt }},
+	{"icache_source_hit_total", "Total successful loads from source.", func(s *StatsSnapshot) int64 { return s.SourceHitCnt }},
+	{"icache_source_error_total", "Total failed loads from source.", func(s *StatsSnapshot) int64 { return s.SourceErrCnt }},
+}
+
+// promEvicts evict counter by reason
+var promEvicts = []struct {
+	reason EvictReason
+	get    func(s *StatsSnapshot) int64
+}{
+	{EvictCapacity, func(s *StatsSnapshot) int64 { return s.EvictCapacityCnt }},
+	{EvictExpired, func(s *StatsSnapshot) int64 { return s.EvictExpiredCnt }},
+	{EvictDeleted, func(s *StatsSnapshot) int64 { return s.EvictDeletedCnt }},
+	{EvictReplaced, func(s *StatsSnapshot) int64 { return s.EvictReplacedCnt }},
+}
+
+// promLatencies latency histogram by op
+var promLatencies = []struct {
+	op  string
+	get func(s *Stats) *Histogram
+}{
+	{"cache_get", func(s *Stats) *Histogram { return s.Latency.CacheGet }},
+	{"cache_set", func(s *Stats) *Histogram { return s.Latency.CacheSet }},
+	{"source", func(s *Stats) *Histogram { return s.Latency.Source }},
+	{"flight_wait", func(s *Stats) *Histogram { return s.Latency.FlightWait }},
+}
+
+// promBackends backend stats
+var promBackends = []struct {
+	name string
+	help string
+	typ  string
+	get  func(b *BackendStats) int64
+}{
+	{"icache_backend_items", "CacheIf item cnt.", "gauge", func(b *BackendStats) int64 { return b.Len }},
+	{"icache_backend_capacity", "CacheIf max item cnt.", "gauge", func(b *BackendStats) int64 { return b.Capacity }},
+	{"icache_backend_bytes", "CacheIf key and value bytes.", "gauge", func(b *BackendStats) int64 { return b.Bytes }},
+	{"icache_backend_evictions_total", "Total CacheIf evictions by capacity.", "counter", func(b *BackendStats) int64 { return b.Evictions }},
+	{"icache_backend_expirations_total", "Total CacheIf expirations.", "counter", func(b *BackendStats) int64 { return b.Expirations }},
+}
+
+// promCompresses compression stats
+var promCompresses = []struct {
+	name string
+	help string
+	get  func(c *CompressStats) int64
+}{
+	{"icache_compress_values_total", "Total values set through compression layer.", func(c *CompressStats) int64 { return c.SetCnt }},
+	{"icache_compress_compressed_total", "Total values stored compressed.", func(c *CompressStats) int64 { return c.CompressedCnt }},
+	{"icache_compress_raw_bytes_total", "Total value bytes before compression.", func(c *CompressStats) int64 { return c.RawBytes }},
+	{"icache_compress_stored_bytes_total", "Total value bytes stored after compression.", func(c *CompressStats) int64 { return c.StoredBytes }},
+	{"icache_compress_decode_errors_total", "Total values fail to decode.", func(c *CompressStats) int64 { return c.DecodeErrCnt }},
+}
+
+// PromHandler render stats of registered ICache in prometheus text exposition format
+type PromHandler struct {
+	reg *Registry
+}
+
+// NewPromHandler new prometheus handler
+func NewPromHandler(reg *Registry) *PromHandler {
+	return &PromHandler{reg: reg}
+}
+
+// ServeHTTP http handler
+func (h *PromHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	h.write(bw)
+	bw.Flush()
+}
+
+func (h *PromHandler) write(w *bufio.Writer) {
+	type namedCache struct {
+		label string
+		ic    *ICache
+		snap  StatsSnapshot
+	}
+	var caches []namedCache
+	h.reg.Range(func(strName string, ic *ICache) bool {
+		caches = append(caches, namedCache{label: `cache="` + promEscape(strName) + `"`, ic: ic, snap: ic.Snapshot()})
+		return true
+	})
+
+	for _, c := range promCounters {
+		promHeader(w, c.name, c.help, "counter")
+		for _, nc := range caches {
+			promSample(w, c.name, nc.label, float64(c.get(&nc.snap)))
+		}
+	}
+
+	promHeader(w, "icache_evict_total", "Total CacheIf evictions by reason.", "counter")
+	for _, nc := range caches {
+		for _, e := range promEvicts {
+			promSample(w, "icache_evict_total", nc.label+`,reason="`+e.reason.String()+`"`, float64(e.get(&nc.snap)))
+		}
+	}
+
+	promHeader(w, "icache_latency_seconds", "ICache op latency.", "summary")
+	for _, nc := range caches {
+		for _, l := range promLatencies {
+			hist := l.get(&nc.ic.stats)
+			if hist == nil {
+				continue
+			}
+			label := nc.label + `,op="` + l.op + `"`
+			for _, q := range promQuantiles {
+				promSample(w, "icache_latency_seconds", label+`,quantile="`+strconv.FormatFloat(q, 'g', -1, 64)+`"`,
+					hist.Percentile(q*100).Seconds())
+			}
+			promSample(w, "icache_latency_seconds_sum", label, hist.Sum().Seconds())
+			promSample(w, "icache_latency_seconds_count", label, float64(hist.Count()))
+		}
+	}
+
+	for _, b := range promBackends {
+		promHeader(w, b.name, b.help, b.typ)
+		for _, nc := range caches {
+			if nc.snap.HasBackend {
+				promSample(w, b.name, nc.label, float64(b.get(&nc.snap.Backend)))
+			}
+		}
+	}
+
+	for _, c := range promCompresses {
+		promHeader(w, c.name, c.help, "counter")
+		for _, nc := range caches {
+			if nc.snap.HasCompress {
+				promSample(w, c.name, nc.label, float64(c.get(&nc.snap.Compress)))
+			}
+		}
+	}
+}
+
+func promHeader(w *bufio.Writer, strName string, strHelp string, strType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", strName, strHelp, strName, strType)
+}
+
+func promSample(w *bufio.Writer, strName string, strLabel string, val float64) {
+	fmt.Fprintf(w, "%s{%s} %s\n", strName, strLabel, strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+var promReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promEscape escape label value
+func promEscape(s string) string {
+	return promReplacer.Replace(s)
+}
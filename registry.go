@@ -0,0 +1,57 @@
+package icache
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registry named ICache registry, used by exporters and admin handlers
+type Registry struct {
+	mu     sync.RWMutex
+	caches map[string]*ICache
+}
+
+// NewRegistry new registry
+func NewRegistry() *Registry {
+	return &Registry{caches: make(map[string]*ICache)}
+}
+
+// Register register ICache with name, replace old one with the same name
+func (r *Registry) Register(strName string, ic *ICache) {
+	r.mu.Lock()
+	r.caches[strName] = ic
+	r.mu.Unlock()
+}
+
+// Unregister unregister ICache
+func (r *Registry) Unregister(strName string) {
+	r.mu.Lock()
+	delete(r.caches, strName)
+	r.mu.Unlock()
+}
+
+// Get get ICache by name
+func (r *Registry) Get(strName string) (*ICache, bool) {
+	r.mu.RLock()
+	ic, ok := r.caches[strName]
+	r.mu.RUnlock()
+	return ic, ok
+}
+
+// Range range ICache sorted by name, stop when fn return false
+func (r *Registry) Range(fn func(strName string, ic *ICache) bool) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.caches))
+	caches := make(map[string]*ICache, len(r.caches))
+	for strName, ic := range r.caches {
+		names = append(names, strName)
+		caches[strName] = ic
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	for _, strName := range names {
+		if !fn(strName, caches[strName]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Package sim replays access traces against CacheIf backends and reports hit ratios,
+// use it to size caches from production traces.
+//
+// Every access is a Get, a miss is followed by a Set of a Size bytes value,
+// like ICache loading from source. Trace timestamps drive a FakeClock,
+// so ttl and idle timeout expire in trace time.
+package sim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/iglev/icache"
+)
+
+// Factory new cache with clock, pass clock to backend options such as icache.SetLRUClock
+type Factory func(clock icache.Clock) icache.CacheIf
+
+// Config cache config to simulate
+type Config struct {
+	Name string
+	New  Factory
+	TTL  time.Duration // ttl of set on miss, 0 never expire
+	Idle time.Duration // idle timeout of set on miss, CacheIf must implement CacheExpireIf
+}
+
+// LRUObjConfig config of NewLRUObjCacheWithOpts with iSize capacity
+func LRUObjConfig(iSize int, ttl time.Duration) Config {
+	return Config{
+		Name: fmt.Sprintf("lru-obj-%d", iSize),
+		New: func(clock icache.Clock) icache.CacheIf {
+			return icache.NewLRUObjCacheWithOpts(iSize, icache.SetLRUClock(clock))
+		},
+		TTL: ttl,
+	}
+}
+
+// Result simulation result of one config
+type Result struct {
+	Name        string
+	Requests    int64
+	Hits        int64
+	Bytes       int64 // requested bytes
+	HitBytes    int64
+	Evictions   int64 // evicted by capacity, CacheIf must implement CacheEvictIf
+	Expirations int64 // expired by ttl or idle timeout, CacheIf must implement CacheEvictIf
+	Errors      int64 // CacheIf errors other than not found
+}
+
+// HitRatio hits / requests
+func (r *Result) HitRatio() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Requests)
+}
+
+// ByteHitRatio hit bytes / requested bytes
+func (r *Result) ByteHitRatio() float64 {
+	if r.Bytes == 0 {
+		return 0
+	}
+	return float64(r.HitBytes) / float64(r.Bytes)
+}
+
+// String one line summary
+func (r *Result) String() string {
+	return fmt.Sprintf("%s: requests=%d hit_ratio=%.4f byte_hit_ratio=%.4f evictions=%d expirations=%d errors=%d",
+		r.Name, r.Requests, r.HitRatio(), r.ByteHitRatio(), r.Evictions, r.Expirations, r.Errors)
+}
+
+// runner replay state of one config
+type runner struct {
+	cfg    Config
+	cache  icache.CacheIf
+	result Result
+}
+
+func (rn *runner) access(ctx context.Context, a Access, val []byte) {
+	rn.result.Requests++
+	rn.result.Bytes += a.Size
+	_, err := rn.cache.Get(ctx, a.Key)
+	if err == nil {
+		rn.result.Hits++
+		rn.result.HitBytes += a.Size
+		return
+	}
+	if !rn.cache.IsErrNotFound(err) {
+		rn.result.Errors++
+	}
+	if err := rn.set(ctx, a.Key, val); err != nil {
+		rn.result.Errors++
+	}
+}
+
+func (rn *runner) set(ctx context.Context, strKey string, val []byte) error {
+	if expireCache, ok := rn.cache.(icache.CacheExpireIf); ok {
+		return expireCache.SetWithExpire(ctx, strKey, val, icache.ExpireOpt{TTL: rn.cfg.TTL, Idle: rn.cfg.Idle})
+	}
+	if rn.cfg.Idle > 0 {
+		return icache.ErrNotSupport
+	}
+	return rn.cache.Set(ctx, strKey, val, int32((rn.cfg.TTL+time.Second-1)/time.Second))
+}
+
+// Replay replay trace against every config in one pass, accesses with
+// timestamp before the previous one are replayed at the previous time
+func Replay(ctx context.Context, tr TraceReader, cfgs ...Config) ([]Result, error) {
+	clock := icache.NewFakeClock(time.Unix(0, 0))
+	runners := make([]*runner, len(cfgs))
+	for i, cfg := range cfgs {
+		rn := &runner{cfg: cfg, cache: cfg.New(clock)}
+		rn.result.Name = cfg.Name
+		if evictCache, ok := rn.cache.(icache.CacheEvictIf); ok {
+			evictCache.AddEvictFunc(func(strKey string, valIf interface{}, reason icache.EvictReason) {
+				switch reason {
+				case icache.EvictCapacity:
+					atomic.AddInt64(&rn.result.Evictions, 1)
+				case icache.EvictExpired:
+					atomic.AddInt64(&rn.result.Expirations, 1)
+				}
+			})
+		}
+		if closer, ok := rn.cache.(io.Closer); ok {
+			defer closer.Close()
+		}
+		runners[i] = rn
+	}
+
+	// values share one zero buffer, caches only keep the slice header
+	var buf []byte
+	var now int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		a, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if a.Ts > now {
+			now = a.Ts
+			clock.Set(time.Unix(0, now*int64(time.Millisecond)))
+		}
+		if int64(len(buf)) < a.Size {
+			buf = make([]byte, a.Size)
+		}
+		for _, rn := range runners {
+			rn.access(ctx, a, buf[:a.Size])
+		}
+	}
+
+	results := make([]Result, len(runners))
+	for i, rn := range runners {
+		results[i] = rn.result
+		results[i].Evictions = atomic.LoadInt64(&rn.result.Evictions)
+		results[i].Expirations = atomic.LoadInt64(&rn.result.Expirations)
+	}
+	return results, nil
+}
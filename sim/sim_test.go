@@ -0,0 +1,139 @@
+package sim
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, tr TraceReader) []Access {
+	var accesses []Access
+	for {
+		a, err := tr.Next()
+		if err == io.EOF {
+			return accesses
+		}
+		if err != nil {
+			t.Fatalf("Next err=%+v", err)
+		}
+		accesses = append(accesses, a)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	text := "# key size ts\na 10 1000\n\nb 20\tc\n"
+	if _, err := OpenTrace(strings.NewReader(text)); err != nil {
+		t.Fatalf("OpenTrace err=%+v", err)
+	}
+	tr, _ := OpenTrace(strings.NewReader(text))
+	tr.Next()
+	if _, err := tr.Next(); err == nil || !strings.Contains(err.Error(), "line 4") {
+		t.Fatalf("bad timestamp err=%+v", err)
+	}
+
+	accesses := []Access{{"a", 10, 1000}, {"b", 20, 0}, {"中文", 1 << 20, 5000}, {"a", 10, 4000}}
+	var buf bytes.Buffer
+	bw, _ := NewBinaryWriter(&buf)
+	for _, a := range accesses {
+		if err := bw.Write(a); err != nil {
+			t.Fatalf("Write err=%+v", err)
+		}
+	}
+	bw.Flush()
+	tr, err := OpenTrace(&buf)
+	if err != nil {
+		t.Fatalf("OpenTrace err=%+v", err)
+	}
+	got := readAll(t, tr)
+	if len(got) != len(accesses) {
+		t.Fatalf("got=%+v", got)
+	}
+	for i := range got {
+		if got[i] != accesses[i] {
+			t.Fatalf("idx=%d got=%+v want=%+v", i, got[i], accesses[i])
+		}
+	}
+}
+
+func TestBinaryTraceCorrupt(t *testing.T) {
+	record := func(keyLen uint64, key string, size uint64) []byte {
+		b := append([]byte(traceMagic), traceVersion)
+		b = append(b, uvarint(keyLen)...)
+		b = append(b, key...)
+		b = append(b, uvarint(size)...)
+		return append(b, 0)
+	}
+	cases := map[string][]byte{
+		"size overflow int64": record(1, "a", math.MaxUint64),
+		"size too large":      record(1, "a", MaxValueSize+1),
+		"key too long":        record(maxKeyLen+1, "a", 1),
+		"truncated key":       record(10, "a", 1),
+		"truncated record":    append([]byte(traceMagic), traceVersion, 1, 'a'),
+	}
+	for name, b := range cases {
+		tr, err := NewBinaryReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s NewBinaryReader err=%+v", name, err)
+		}
+		if _, err := tr.Next(); err != ErrBadTrace {
+			t.Fatalf("%s Next err=%+v", name, err)
+		}
+		tr, _ = NewBinaryReader(bytes.NewReader(b))
+		if _, err := Replay(context.Background(), tr, LRUObjConfig(10, 0)); err != ErrBadTrace {
+			t.Fatalf("%s Replay err=%+v", name, err)
+		}
+	}
+	for _, text := range []string{"a 99999999999999999999\n", "a 1000000000000000 1\n"} {
+		if _, err := NewTextReader(strings.NewReader(text)).Next(); !errors.Is(err, ErrBadTrace) {
+			t.Fatalf("text=%q err=%+v", text, err)
+		}
+		if _, err := Replay(context.Background(), NewTextReader(strings.NewReader(text)), LRUObjConfig(10, 0)); !errors.Is(err, ErrBadTrace) {
+			t.Fatalf("text=%q Replay err=%+v", text, err)
+		}
+	}
+}
+
+func uvarint(v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return tmp[:binary.PutUvarint(tmp[:], v)]
+}
+
+func TestReplay(t *testing.T) {
+	// a b c a b c with capacity 2 always misses, capacity 3 hits the second round
+	text := `a 100 1000
+b 10 1000
+c 10 1000
+a 100 2000
+b 10 2000
+c 10 2000
+a 100 9000
+`
+	results, err := Replay(context.Background(), NewTextReader(strings.NewReader(text)),
+		LRUObjConfig(2, 0),
+		LRUObjConfig(3, 0),
+		LRUObjConfig(3, 5*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("Replay err=%+v", err)
+	}
+	want := []Result{
+		{Name: "lru-obj-2", Requests: 7, Hits: 0, Bytes: 340, HitBytes: 0, Evictions: 5},
+		{Name: "lru-obj-3", Requests: 7, Hits: 4, Bytes: 340, HitBytes: 220},
+		// a expires at 6000
+		{Name: "lru-obj-3", Requests: 7, Hits: 3, Bytes: 340, HitBytes: 120, Expirations: 1},
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Fatalf("idx=%d result=%+v want=%+v", i, results[i], want[i])
+		}
+	}
+	if r := results[1]; r.HitRatio() != 4.0/7 || r.ByteHitRatio() != 220.0/340 {
+		t.Fatalf("result=%s", r.String())
+	}
+}
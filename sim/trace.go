@@ -0,0 +1,187 @@
+package sim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	traceMagic   = "ICTRACE"
+	traceVersion = 1
+
+	maxKeyLen = 1 << 16
+	// MaxValueSize max value size of an access, replay allocates values of this size
+	MaxValueSize = 64 << 20
+)
+
+var (
+	// ErrBadTrace malformed trace
+	ErrBadTrace = errors.New("sim: bad trace")
+)
+
+// Access one access in trace
+type Access struct {
+	Key  string
+	Size int64 // value size in bytes
+	Ts   int64 // unix milli, 0 means unknown, ttl is not simulated for it
+}
+
+// TraceReader trace reader, Next return io.EOF when done
+type TraceReader interface {
+	Next() (Access, error)
+}
+
+// TextReader text trace, one access per line: key size [timestamp],
+// fields separated by spaces or tabs, timestamp in unix milli,
+// blank lines and lines starting with # are skipped
+type TextReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewTextReader new text trace reader
+func NewTextReader(r io.Reader) *TextReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxKeyLen+64)
+	return &TextReader{scanner: scanner}
+}
+
+// Next next access
+func (tr *TextReader) Next() (Access, error) {
+	for tr.scanner.Scan() {
+		tr.line++
+		strLine := strings.TrimSpace(tr.scanner.Text())
+		if strLine == "" || strLine[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(strLine)
+		if len(fields) < 2 || len(fields) > 3 {
+			return Access{}, fmt.Errorf("%w: line %d: need key size [timestamp]", ErrBadTrace, tr.line)
+		}
+		a := Access{Key: fields[0]}
+		var err error
+		if a.Size, err = strconv.ParseInt(fields[1], 10, 64); err != nil || a.Size < 0 || a.Size > MaxValueSize {
+			return Access{}, fmt.Errorf("%w: line %d: bad size %q", ErrBadTrace, tr.line, fields[1])
+		}
+		if len(fields) == 3 {
+			if a.Ts, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+				return Access{}, fmt.Errorf("%w: line %d: bad timestamp %q", ErrBadTrace, tr.line, fields[2])
+			}
+		}
+		return a, nil
+	}
+	if err := tr.scanner.Err(); err != nil {
+		return Access{}, err
+	}
+	return Access{}, io.EOF
+}
+
+// BinaryReader binary trace
+//
+//	header: magic "ICTRACE", version byte
+//	record: uvarint key len, key, uvarint size, varint timestamp delta from previous record
+type BinaryReader struct {
+	r    *bufio.Reader
+	prev int64
+}
+
+// NewBinaryReader new binary trace reader and read header
+func NewBinaryReader(r io.Reader) (*BinaryReader, error) {
+	br := &BinaryReader{r: bufio.NewReader(r)}
+	if err := readHeader(br.r); err != nil {
+		return nil, err
+	}
+	return br, nil
+}
+
+func readHeader(r *bufio.Reader) error {
+	header := make([]byte, len(traceMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return ErrBadTrace
+	}
+	if string(header[:len(traceMagic)]) != traceMagic || header[len(traceMagic)] != traceVersion {
+		return ErrBadTrace
+	}
+	return nil
+}
+
+// Next next access
+func (br *BinaryReader) Next() (Access, error) {
+	if _, err := br.r.Peek(1); err == io.EOF {
+		return Access{}, io.EOF
+	}
+	keyLen, err := binary.ReadUvarint(br.r)
+	if err != nil || keyLen > maxKeyLen {
+		return Access{}, ErrBadTrace
+	}
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(br.r, key); err != nil {
+		return Access{}, ErrBadTrace
+	}
+	size, err := binary.ReadUvarint(br.r)
+	if err != nil || size > MaxValueSize {
+		return Access{}, ErrBadTrace
+	}
+	delta, err := binary.ReadVarint(br.r)
+	if err != nil {
+		return Access{}, ErrBadTrace
+	}
+	br.prev += delta
+	return Access{Key: string(key), Size: int64(size), Ts: br.prev}, nil
+}
+
+// BinaryWriter binary trace writer
+type BinaryWriter struct {
+	w    *bufio.Writer
+	prev int64
+	buf  []byte
+}
+
+// NewBinaryWriter new binary trace writer and write header
+func NewBinaryWriter(w io.Writer) (*BinaryWriter, error) {
+	bw := &BinaryWriter{w: bufio.NewWriter(w)}
+	if _, err := bw.w.WriteString(traceMagic); err != nil {
+		return nil, err
+	}
+	if err := bw.w.WriteByte(traceVersion); err != nil {
+		return nil, err
+	}
+	return bw, nil
+}
+
+// Write write access
+func (bw *BinaryWriter) Write(a Access) error {
+	if len(a.Key) > maxKeyLen || a.Size < 0 || a.Size > MaxValueSize {
+		return fmt.Errorf("sim: bad access key=%q size=%d", a.Key, a.Size)
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	b := bw.buf[:0]
+	b = append(b, tmp[:binary.PutUvarint(tmp[:], uint64(len(a.Key)))]...)
+	b = append(b, a.Key...)
+	b = append(b, tmp[:binary.PutUvarint(tmp[:], uint64(a.Size))]...)
+	b = append(b, tmp[:binary.PutVarint(tmp[:], a.Ts-bw.prev)]...)
+	bw.buf = b
+	bw.prev = a.Ts
+	_, err := bw.w.Write(b)
+	return err
+}
+
+// Flush flush buffered data
+func (bw *BinaryWriter) Flush() error {
+	return bw.w.Flush()
+}
+
+// OpenTrace detect binary trace by magic, text trace otherwise
+func OpenTrace(r io.Reader) (TraceReader, error) {
+	br := bufio.NewReader(r)
+	if header, _ := br.Peek(len(traceMagic)); bytes.Equal(header, []byte(traceMagic)) {
+		return NewBinaryReader(br)
+	}
+	return NewTextReader(br), nil
+}
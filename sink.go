@@ -3,6 +3,7 @@ package icache
 import (
 	"fmt"
 	"reflect"
+	"time"
 )
 
 // SinkIf sink interface
@@ -15,6 +16,33 @@ type SinkIf interface {
 	SetTTL(int32) error
 }
 
+// SinkTTLDurationIf optional sink interface, time.Duration ttl, built-in sinks implement it
+type SinkTTLDurationIf interface {
+	SetTTLDuration(time.Duration) error
+}
+
+// SinkIdleTTLIf optional sink interface, idle timeout of loaded value, built-in sinks implement it.
+// A value with idle timeout is not cached if CacheIf does not implement CacheExpireIf
+type SinkIdleTTLIf interface {
+	SetIdleTTL(time.Duration) error
+}
+
+// SetSinkIdleTTL set idle timeout of dest, ErrNotSupport if dest does not implement SinkIdleTTLIf
+func SetSinkIdleTTL(dest SinkIf, idle time.Duration) error {
+	if idleSink, ok := dest.(SinkIdleTTLIf); ok {
+		return idleSink.SetIdleTTL(idle)
+	}
+	return ErrNotSupport
+}
+
+// SetSinkTTLDuration set ttl of dest, rounded up to seconds if dest does not implement SinkTTLDurationIf
+func SetSinkTTLDuration(dest SinkIf, ttl time.Duration) error {
+	if ttlSink, ok := dest.(SinkTTLDurationIf); ok {
+		return ttlSink.SetTTLDuration(ttl)
+	}
+	return dest.SetTTL(int32((ttl + time.Second - 1) / time.Second))
+}
+
 ////////////////////////////////////////////////////////
 // stringSink
 
@@ -24,8 +52,9 @@ func StringSink(sp *string) SinkIf {
 }
 
 type stringSink struct {
-	sp  *string
-	ttl int32
+	sp   *string
+	ttl  time.Duration
+	idle time.Duration
 }
 
 // SetView set view
@@ -39,14 +68,16 @@ func (ss *stringSink) SetView(v View) error {
 		return fmt.Errorf("not string view")
 	}
 	ss.ttl = v.ttl
+	ss.idle = v.idle
 	return nil
 }
 
 // GetView get view
 func (ss *stringSink) GetView() (View, error) {
 	v := View{
-		v:   *ss.sp,
-		ttl: ss.ttl,
+		v:    *ss.sp,
+		ttl:  ss.ttl,
+		idle: ss.idle,
 	}
 	return v, nil
 }
@@ -68,9 +99,21 @@ func (ss *stringSink) SetObj(obj interface{}) error {
 	return fmt.Errorf("not support interface obj")
 }
 
-// SetTTL set ttl
+// SetTTL set ttl in seconds
 func (ss *stringSink) SetTTL(iTTL int32) error {
-	ss.ttl = iTTL
+	ss.ttl = time.Duration(iTTL) * time.Second
+	return nil
+}
+
+// SetTTLDuration set ttl
+func (ss *stringSink) SetTTLDuration(ttl time.Duration) error {
+	ss.ttl = ttl
+	return nil
+}
+
+// SetIdleTTL set idle timeout
+func (ss *stringSink) SetIdleTTL(idle time.Duration) error {
+	ss.idle = idle
 	return nil
 }
 
@@ -83,8 +126,9 @@ func ByteSink(bp *[]byte) SinkIf {
 }
 
 type byteSink struct {
-	bp  *[]byte
-	ttl int32
+	bp   *[]byte
+	ttl  time.Duration
+	idle time.Duration
 }
 
 // SetView set view
@@ -98,14 +142,16 @@ func (bs *byteSink) SetView(v View) error {
 		return fmt.Errorf("not byte view")
 	}
 	bs.ttl = v.ttl
+	bs.idle = v.idle
 	return nil
 }
 
 // GetView get view
 func (bs *byteSink) GetView() (View, error) {
 	v := View{
-		v:   *bs.bp,
-		ttl: bs.ttl,
+		v:    *bs.bp,
+		ttl:  bs.ttl,
+		idle: bs.idle,
 	}
 	return v, nil
 }
@@ -127,9 +173,21 @@ func (bs *byteSink) SetObj(obj interface{}) error {
 	return fmt.Errorf("not support interface obj")
 }
 
-// SetTTL set ttl
+// SetTTL set ttl in seconds
 func (bs *byteSink) SetTTL(iTTL int32) error {
-	bs.ttl = iTTL
+	bs.ttl = time.Duration(iTTL) * time.Second
+	return nil
+}
+
+// SetTTLDuration set ttl
+func (bs *byteSink) SetTTLDuration(ttl time.Duration) error {
+	bs.ttl = ttl
+	return nil
+}
+
+// SetIdleTTL set idle timeout
+func (bs *byteSink) SetIdleTTL(idle time.Duration) error {
+	bs.idle = idle
 	return nil
 }
 
@@ -142,8 +200,9 @@ func ObjSink(pObj interface{}) SinkIf {
 }
 
 type objSink struct {
-	obj interface{}
-	ttl int32
+	obj  interface{}
+	ttl  time.Duration
+	idle time.Duration
 }
 
 // SetView set view
@@ -158,14 +217,16 @@ func (os *objSink) SetView(inView View) error {
 	}
 	objValue.Elem().Set(reflect.ValueOf(inView.v).Elem())
 	os.ttl = inView.ttl
+	os.idle = inView.idle
 	return nil
 }
 
 // GetView get view
 func (os *objSink) GetView() (View, error) {
 	v := View{
-		v:   os.obj,
-		ttl: os.ttl,
+		v:    os.obj,
+		ttl:  os.ttl,
+		idle: os.idle,
 	}
 	return v, nil
 }
@@ -194,8 +255,20 @@ func (os *objSink) SetObj(inObj interface{}) error {
 	return nil
 }
 
-// SetTTL set ttl
+// SetTTL set ttl in seconds
 func (os *objSink) SetTTL(iTTL int32) error {
-	os.ttl = iTTL
+	os.ttl = time.Duration(iTTL) * time.Second
+	return nil
+}
+
+// SetTTLDuration set ttl
+func (os *objSink) SetTTLDuration(ttl time.Duration) error {
+	os.ttl = ttl
+	return nil
+}
+
+// SetIdleTTL set idle timeout
+func (os *objSink) SetIdleTTL(idle time.Duration) error {
+	os.idle = idle
 	return nil
 }
@@ -0,0 +1,298 @@
+// Package snapshot provides the cache snapshot file format.
+//
+// A snapshot file is a header followed by records until EOF.
+//
+//	header: magic "ICSNAP", version byte
+//	record v1: uvarint key len, key, kind byte, uvarint value len, value, varint expire ts
+//	record v2: v1 record, varint idle, uint32 crc32 of the record
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+const (
+	// Version1 record without idle timeout and checksum
+	Version1 = 1
+	// Version2 record with idle timeout and crc32 checksum
+	Version2 = 2
+	// LatestVersion latest version
+	LatestVersion = Version2
+
+	magic = "ICSNAP"
+
+	maxKeyLen = 1 << 16
+	maxValLen = 1 << 30
+)
+
+var (
+	// ErrBadMagic not a snapshot file
+	ErrBadMagic = errors.New("snapshot: bad magic")
+	// ErrVersion unsupported version
+	ErrVersion = errors.New("snapshot: unsupported version")
+	// ErrChecksum record checksum mismatch
+	ErrChecksum = errors.New("snapshot: checksum mismatch")
+	// ErrCorrupt corrupt record
+	ErrCorrupt = errors.New("snapshot: corrupt record")
+)
+
+// Kind value kind
+type Kind byte
+
+const (
+	// KindBytes []byte value
+	KindBytes Kind = iota
+	// KindString string value
+	KindString
+	// KindJSON object value encoded as json
+	KindJSON
+)
+
+// String kind name
+func (k Kind) String() string {
+	switch k {
+	case KindBytes:
+		return "bytes"
+	case KindString:
+		return "string"
+	case KindJSON:
+		return "json"
+	default:
+		return "unknown"
+	}
+}
+
+// Record snapshot record
+type Record struct {
+	Key      string
+	Kind     Kind
+	Value    []byte
+	ExpireTs int64         // unix nano, 0 means never expire
+	Idle     time.Duration // idle timeout, always 0 in v1
+}
+
+// TTL remaining ttl at now, 0 means never expire, < 0 means expired
+func (r *Record) TTL(now time.Time) time.Duration {
+	if r.ExpireTs == 0 {
+		return 0
+	}
+	ttl := time.Duration(r.ExpireTs - now.UnixNano())
+	if ttl == 0 {
+		ttl = -1
+	}
+	return ttl
+}
+
+// Writer snapshot writer
+type Writer struct {
+	w       *bufio.Writer
+	version int
+	buf     []byte
+}
+
+// NewWriter new writer and write header
+func NewWriter(w io.Writer, version int) (*Writer, error) {
+	if version != Version1 && version != Version2 {
+		return nil, ErrVersion
+	}
+	sw := &Writer{w: bufio.NewWriter(w), version: version}
+	if _, err := sw.w.WriteString(magic); err != nil {
+		return nil, err
+	}
+	if err := sw.w.WriteByte(byte(version)); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// Write write record
+func (sw *Writer) Write(r *Record) error {
+	if len(r.Key) > maxKeyLen || len(r.Value) > maxValLen {
+		return fmt.Errorf("snapshot: key or value too large, key=%q", r.Key)
+	}
+	b := sw.buf[:0]
+	b = appendUvarint(b, uint64(len(r.Key)))
+	b = append(b, r.Key...)
+	b = append(b, byte(r.Kind))
+	b = appendUvarint(b, uint64(len(r.Value)))
+	b = append(b, r.Value...)
+	b = appendVarint(b, r.ExpireTs)
+	if sw.version >= Version2 {
+		b = appendVarint(b, int64(r.Idle))
+		var crc [4]byte
+		binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(b))
+		b = append(b, crc[:]...)
+	}
+	sw.buf = b
+	_, err := sw.w.Write(b)
+	return err
+}
+
+// Flush flush buffered data
+func (sw *Writer) Flush() error {
+	return sw.w.Flush()
+}
+
+// Reader snapshot reader
+type Reader struct {
+	r       *bufio.Reader
+	version int
+	crc     crcReader
+}
+
+// NewReader new reader and read header
+func NewReader(r io.Reader) (*Reader, error) {
+	sr := &Reader{r: bufio.NewReader(r)}
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(sr.r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrBadMagic
+		}
+		return nil, err
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, ErrBadMagic
+	}
+	sr.version = int(header[len(magic)])
+	if sr.version != Version1 && sr.version != Version2 {
+		return nil, ErrVersion
+	}
+	sr.crc.r = sr.r
+	return sr, nil
+}
+
+// Version file version
+func (sr *Reader) Version() int {
+	return sr.version
+}
+
+// Read read next record, return io.EOF when done
+func (sr *Reader) Read() (*Record, error) {
+	if _, err := sr.r.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+	sr.crc.crc = 0
+	r := &Record{}
+	keyLen, err := binary.ReadUvarint(&sr.crc)
+	if err != nil {
+		return nil, corrupt(err)
+	}
+	if keyLen > maxKeyLen {
+		return nil, ErrCorrupt
+	}
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(&sr.crc, key); err != nil {
+		return nil, corrupt(err)
+	}
+	r.Key = string(key)
+	kind, err := sr.crc.ReadByte()
+	if err != nil {
+		return nil, corrupt(err)
+	}
+	r.Kind = Kind(kind)
+	valLen, err := binary.ReadUvarint(&sr.crc)
+	if err != nil {
+		return nil, corrupt(err)
+	}
+	if valLen > maxValLen {
+		return nil, ErrCorrupt
+	}
+	r.Value = make([]byte, valLen)
+	if _, err := io.ReadFull(&sr.crc, r.Value); err != nil {
+		return nil, corrupt(err)
+	}
+	if r.ExpireTs, err = binary.ReadVarint(&sr.crc); err != nil {
+		return nil, corrupt(err)
+	}
+	if sr.version >= Version2 {
+		idle, err := binary.ReadVarint(&sr.crc)
+		if err != nil {
+			return nil, corrupt(err)
+		}
+		r.Idle = time.Duration(idle)
+		sum := sr.crc.crc
+		var crc [4]byte
+		if _, err := io.ReadFull(sr.r, crc[:]); err != nil {
+			return nil, corrupt(err)
+		}
+		if binary.LittleEndian.Uint32(crc[:]) != sum {
+			return nil, ErrChecksum
+		}
+	}
+	return r, nil
+}
+
+// Convert copy records from r to w in version, idle timeout is dropped when converting to v1
+func Convert(w io.Writer, r io.Reader, version int) (int, error) {
+	sr, err := NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	sw, err := NewWriter(w, version)
+	if err != nil {
+		return 0, err
+	}
+	cnt := 0
+	for {
+		rec, err := sr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cnt, err
+		}
+		if version < Version2 {
+			rec.Idle = 0
+		}
+		if err := sw.Write(rec); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+	return cnt, sw.Flush()
+}
+
+// crcReader compute crc32 of bytes read
+type crcReader struct {
+	r   *bufio.Reader
+	crc uint32
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.crc = crc32.Update(cr.crc, crc32.IEEETable, p[:n])
+	return n, err
+}
+
+func (cr *crcReader) ReadByte() (byte, error) {
+	c, err := cr.r.ReadByte()
+	if err == nil {
+		cr.crc = crc32.Update(cr.crc, crc32.IEEETable, []byte{c})
+	}
+	return c, err
+}
+
+func corrupt(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrCorrupt
+	}
+	return err
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
@@ -1,6 +1,10 @@
 package icache
 
-import "sync/atomic"
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
 
 // Stats stat
 type Stats struct {
@@ -13,44 +17,314 @@ type Stats struct {
 	SourceCnt    int64 // get from source cnt
 	SourceHitCnt int64 // get from source hit cnt
 	SourceErrCnt int64 // get from source err cnt
+
+	EvictCapacityCnt int64 // CacheIf evicted by capacity cnt
+	EvictExpiredCnt  int64 // CacheIf evicted by expire cnt
+	EvictDeletedCnt  int64 // CacheIf deleted cnt
+	EvictReplacedCnt int64 // CacheIf replaced cnt
+
+	Latency LatencyStats // latency histograms, shared between copies of Stats
+
+	ops unsafe.Pointer // *opCounters of committed ops, swapped by Reset
+}
+
+// opStat counters of one op, committed to Stats at once
+type opStat struct {
+	get, del, hit, err, miss, source, sourceHit, sourceErr int64
+}
+
+// opCounters outcome counters of committed ops, a Get adds exactly one of
+// getOther, hit and miss, a source load one of sourceHit and sourceErr,
+// GetCnt and SourceCnt are derived from them, so snapshots need no lock
+type opCounters struct {
+	getOther  int64 // get neither hit nor miss, e.g. nil dest or short-circuited
+	hit       int64
+	miss      int64
+	sourceHit int64
+	sourceErr int64
+}
+
+// LatencyStats latency histograms
+type LatencyStats struct {
+	CacheGet   *Histogram // CacheIf get latency
+	CacheSet   *Histogram // CacheIf set latency
+	Source     *Histogram // GetterIf load latency
+	FlightWait *Histogram // wait for other goroutine loading the same key
+}
+
+func newLatencyStats() LatencyStats {
+	return LatencyStats{
+		CacheGet:   &Histogram{},
+		CacheSet:   &Histogram{},
+		Source:     &Histogram{},
+		FlightWait: &Histogram{},
+	}
 }
 
 // AddGet add get
 func (s *Stats) AddGet(n int64) {
-	atomic.AddInt64(&s.GetCnt, n)
+	s.add(&s.GetCnt, n)
 }
 
 // AddDel add del
 func (s *Stats) AddDel(n int64) {
-	atomic.AddInt64(&s.DelCnt, n)
+	s.add(&s.DelCnt, n)
 }
 
 // AddHit add hit
 func (s *Stats) AddHit(n int64) {
-	atomic.AddInt64(&s.HitCnt, n)
+	s.add(&s.HitCnt, n)
 }
 
 // AddErr add err
 func (s *Stats) AddErr(n int64) {
-	atomic.AddInt64(&s.ErrCnt, n)
+	s.add(&s.ErrCnt, n)
 }
 
 // AddMiss add miss
 func (s *Stats) AddMiss(n int64) {
-	atomic.AddInt64(&s.MissCnt, n)
+	s.add(&s.MissCnt, n)
 }
 
 // AddSource add source
 func (s *Stats) AddSource(n int64) {
-	atomic.AddInt64(&s.SourceCnt, n)
+	s.add(&s.SourceCnt, n)
 }
 
 // AddSourceHit add source hit
 func (s *Stats) AddSourceHit(n int64) {
-	atomic.AddInt64(&s.SourceHitCnt, n)
+	s.add(&s.SourceHitCnt, n)
 }
 
 // AddSourceErr add source err
 func (s *Stats) AddSourceErr(n int64) {
-	atomic.AddInt64(&s.SourceErrCnt, n)
+	s.add(&s.SourceErrCnt, n)
+}
+
+// AddEvict add evict by reason
+func (s *Stats) AddEvict(reason EvictReason, n int64) {
+	switch reason {
+	case EvictCapacity:
+		s.add(&s.EvictCapacityCnt, n)
+	case EvictExpired:
+		s.add(&s.EvictExpiredCnt, n)
+	case EvictDeleted:
+		s.add(&s.EvictDeletedCnt, n)
+	case EvictReplaced:
+		s.add(&s.EvictReplacedCnt, n)
+	}
+}
+
+func (s *Stats) add(p *int64, n int64) {
+	atomic.AddInt64(p, n)
+}
+
+// opCounters current opCounters, nil until first commit
+func (s *Stats) opCounters() *opCounters {
+	return (*opCounters)(atomic.LoadPointer(&s.ops))
+}
+
+// commit add counters of one op, its get and source outcomes take one atomic add each
+func (s *Stats) commit(op *opStat) {
+	c := s.opCounters()
+	if c == nil {
+		atomic.CompareAndSwapPointer(&s.ops, nil, unsafe.Pointer(&opCounters{}))
+		c = s.opCounters()
+	}
+	switch {
+	case op.hit != 0:
+		atomic.AddInt64(&c.hit, op.hit)
+	case op.miss != 0:
+		atomic.AddInt64(&c.miss, op.miss)
+	case op.get != 0:
+		atomic.AddInt64(&c.getOther, op.get)
+	}
+	switch {
+	case op.sourceHit != 0:
+		atomic.AddInt64(&c.sourceHit, op.sourceHit)
+	case op.sourceErr != 0:
+		atomic.AddInt64(&c.sourceErr, op.sourceErr)
+	}
+	if op.del != 0 {
+		atomic.AddInt64(&s.DelCnt, op.del)
+	}
+	if op.err != 0 {
+		atomic.AddInt64(&s.ErrCnt, op.err)
+	}
+}
+
+// BackendStats CacheIf stats
+type BackendStats struct {
+	Len         int64 // item cnt
+	Capacity    int64 // max item cnt, 0 means unknown
+	Bytes       int64 // key and value bytes, 0 means unknown
+	Evictions   int64 // evicted by capacity cnt
+	Expirations int64 // removed by expire cnt
+}
+
+// CacheStatIf optional cache interface, report backend stats
+type CacheStatIf interface {
+	Stat() BackendStats
+}
+
+// StatsSnapshot stats snapshot, counters are consistent: HitCnt+MissCnt <= GetCnt
+type StatsSnapshot struct {
+	Time     time.Time     // snapshot time
+	Interval time.Duration // set by Delta, time between two snapshots
+
+	GetCnt  int64
+	DelCnt  int64
+	HitCnt  int64
+	ErrCnt  int64
+	MissCnt int64
+
+	SourceCnt    int64
+	SourceHitCnt int64
+	SourceErrCnt int64
+
+	EvictCapacityCnt int64
+	EvictExpiredCnt  int64
+	EvictDeletedCnt  int64
+	EvictReplacedCnt int64
+
+	HasBackend bool         // CacheIf implements CacheStatIf
+	Backend    BackendStats // set by ICache.Snapshot
+
+	HasCompress bool          // CacheIf implements CacheCompressStatIf
+	Compress    CompressStats // set by ICache.Snapshot
+}
+
+// Snapshot snapshot of counters without locking, committed ops are consistent:
+// each Get is counted in GetCnt with its hit or miss, or not at all
+func (s *Stats) Snapshot() StatsSnapshot {
+	snap := StatsSnapshot{
+		Time:             time.Now(),
+		GetCnt:           atomic.LoadInt64(&s.GetCnt),
+		DelCnt:           atomic.LoadInt64(&s.DelCnt),
+		HitCnt:           atomic.LoadInt64(&s.HitCnt),
+		ErrCnt:           atomic.LoadInt64(&s.ErrCnt),
+		MissCnt:          atomic.LoadInt64(&s.MissCnt),
+		SourceCnt:        atomic.LoadInt64(&s.SourceCnt),
+		SourceHitCnt:     atomic.LoadInt64(&s.SourceHitCnt),
+		SourceErrCnt:     atomic.LoadInt64(&s.SourceErrCnt),
+		EvictCapacityCnt: atomic.LoadInt64(&s.EvictCapacityCnt),
+		EvictExpiredCnt:  atomic.LoadInt64(&s.EvictExpiredCnt),
+		EvictDeletedCnt:  atomic.LoadInt64(&s.EvictDeletedCnt),
+		EvictReplacedCnt: atomic.LoadInt64(&s.EvictReplacedCnt),
+	}
+	if c := s.opCounters(); c != nil {
+		getOther := atomic.LoadInt64(&c.getOther)
+		hit := atomic.LoadInt64(&c.hit)
+		miss := atomic.LoadInt64(&c.miss)
+		sourceHit := atomic.LoadInt64(&c.sourceHit)
+		sourceErr := atomic.LoadInt64(&c.sourceErr)
+		snap.GetCnt += getOther + hit + miss
+		snap.HitCnt += hit
+		snap.MissCnt += miss
+		snap.SourceCnt += sourceHit + sourceErr
+		snap.SourceHitCnt += sourceHit
+		snap.SourceErrCnt += sourceErr
+	}
+	return snap
+}
+
+// Reset reset counters and latency histograms, ops in progress are counted before or after Reset
+func (s *Stats) Reset() {
+	if s.opCounters() != nil {
+		atomic.StorePointer(&s.ops, unsafe.Pointer(&opCounters{}))
+	}
+	for _, p := range []*int64{
+		&s.GetCnt, &s.DelCnt, &s.HitCnt, &s.ErrCnt, &s.MissCnt,
+		&s.SourceCnt, &s.SourceHitCnt, &s.SourceErrCnt,
+		&s.EvictCapacityCnt, &s.EvictExpiredCnt, &s.EvictDeletedCnt, &s.EvictReplacedCnt,
+	} {
+		atomic.StoreInt64(p, 0)
+	}
+	s.Latency.CacheGet.Reset()
+	s.Latency.CacheSet.Reset()
+	s.Latency.Source.Reset()
+	s.Latency.FlightWait.Reset()
+}
+
+// load copy Stats with atomic loads
+func (s *Stats) load() Stats {
+	snap := s.Snapshot()
+	return Stats{
+		GetCnt:           snap.GetCnt,
+		DelCnt:           snap.DelCnt,
+		HitCnt:           snap.HitCnt,
+		ErrCnt:           snap.ErrCnt,
+		MissCnt:          snap.MissCnt,
+		SourceCnt:        snap.SourceCnt,
+		SourceHitCnt:     snap.SourceHitCnt,
+		SourceErrCnt:     snap.SourceErrCnt,
+		EvictCapacityCnt: snap.EvictCapacityCnt,
+		EvictExpiredCnt:  snap.EvictExpiredCnt,
+		EvictDeletedCnt:  snap.EvictDeletedCnt,
+		EvictReplacedCnt: snap.EvictReplacedCnt,
+		Latency:          s.Latency,
+	}
+}
+
+// Delta counters increased since prev, for rate computation, backend gauges keep current value
+func (s StatsSnapshot) Delta(prev StatsSnapshot) StatsSnapshot {
+	return StatsSnapshot{
+		Time:             s.Time,
+		Interval:         s.Time.Sub(prev.Time),
+		GetCnt:           s.GetCnt - prev.GetCnt,
+		DelCnt:           s.DelCnt - prev.DelCnt,
+		HitCnt:           s.HitCnt - prev.HitCnt,
+		ErrCnt:           s.ErrCnt - prev.ErrCnt,
+		MissCnt:          s.MissCnt - prev.MissCnt,
+		SourceCnt:        s.SourceCnt - prev.SourceCnt,
+		SourceHitCnt:     s.SourceHitCnt - prev.SourceHitCnt,
+		SourceErrCnt:     s.SourceErrCnt - prev.SourceErrCnt,
+		EvictCapacityCnt: s.EvictCapacityCnt - prev.EvictCapacityCnt,
+		EvictExpiredCnt:  s.EvictExpiredCnt - prev.EvictExpiredCnt,
+		EvictDeletedCnt:  s.EvictDeletedCnt - prev.EvictDeletedCnt,
+		EvictReplacedCnt: s.EvictReplacedCnt - prev.EvictReplacedCnt,
+		HasBackend:       s.HasBackend,
+		Backend: BackendStats{
+			Len:         s.Backend.Len,
+			Capacity:    s.Backend.Capacity,
+			Bytes:       s.Backend.Bytes,
+			Evictions:   s.Backend.Evictions - prev.Backend.Evictions,
+			Expirations: s.Backend.Expirations - prev.Backend.Expirations,
+		},
+		HasCompress: s.HasCompress,
+		Compress: CompressStats{
+			SetCnt:            s.Compress.SetCnt - prev.Compress.SetCnt,
+			CompressedCnt:     s.Compress.CompressedCnt - prev.Compress.CompressedCnt,
+			RawBytes:          s.Compress.RawBytes - prev.Compress.RawBytes,
+			StoredBytes:       s.Compress.StoredBytes - prev.Compress.StoredBytes,
+			DecodeErrCnt:      s.Compress.DecodeErrCnt - prev.Compress.DecodeErrCnt,
+			EncodeErrCnt:      s.Compress.EncodeErrCnt - prev.Compress.EncodeErrCnt,
+			IncompressibleCnt: s.Compress.IncompressibleCnt - prev.Compress.IncompressibleCnt,
+		},
+	}
+}
+
+// Rate cnt per second in Interval, cnt usually a field of Delta result
+func (s StatsSnapshot) Rate(cnt int64) float64 {
+	if s.Interval <= 0 {
+		return 0
+	}
+	return float64(cnt) / s.Interval.Seconds()
+}
+
+// HitRatio cache hit ratio of get ops
+func (s StatsSnapshot) HitRatio() float64 {
+	return ratio(s.HitCnt, s.GetCnt)
+}
+
+// SourceErrRatio source err ratio of source loads
+func (s StatsSnapshot) SourceErrRatio() float64 {
+	return ratio(s.SourceErrCnt, s.SourceCnt)
+}
+
+func ratio(n int64, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
 }
@@ -0,0 +1,143 @@
+package icache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// SpanGet ICache.Get span
+	SpanGet = "icache.Get"
+	// SpanLoad load through flight group span
+	SpanLoad = "icache.load"
+	// SpanSource GetterIf load span
+	SpanSource = "icache.source"
+
+	// AttrKey key attr
+	AttrKey = "icache.key"
+	// AttrHit cache hit attr, bool
+	AttrHit = "icache.hit"
+	// AttrShared result shared from other goroutine in flight group, bool
+	AttrShared = "icache.shared"
+	// AttrSourceErr source load fail attr, bool
+	AttrSourceErr = "icache.source_error"
+)
+
+// Tracer tracer hook, wrap OpenTelemetry tracer to see ICache ops in request traces
+type Tracer interface {
+	Start(ctx context.Context, strName string) (context.Context, Span)
+}
+
+// Span span
+type Span interface {
+	SetAttr(strKey string, valIf interface{})
+	End(err error)
+}
+
+// noopTracer default tracer
+type noopTracer struct{}
+
+// Start start span
+func (noopTracer) Start(ctx context.Context, strName string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+// SetAttr set attr
+func (noopSpan) SetAttr(strKey string, valIf interface{}) {}
+
+// End end span
+func (noopSpan) End(err error) {}
+
+// RecordSpan span recorded by RecordTracer
+type RecordSpan struct {
+	ID       int
+	ParentID int // 0 means root span
+	Name     string
+	Attrs    map[string]interface{}
+	Err      error
+	Start    time.Time
+	End      time.Time
+}
+
+// RecordTracer record ended spans in memory, for tests
+type RecordTracer struct {
+	mu     sync.Mutex
+	nextID int
+	spans  []RecordSpan
+}
+
+// NewRecordTracer new record tracer
+func NewRecordTracer() *RecordTracer {
+	return &RecordTracer{}
+}
+
+type recordSpanCtxKey struct{}
+
+// Start start span
+func (t *RecordTracer) Start(ctx context.Context, strName string) (context.Context, Span) {
+	t.mu.Lock()
+	t.nextID++
+	span := &recordSpan{
+		tracer: t,
+		span: RecordSpan{
+			ID:    t.nextID,
+			Name:  strName,
+			Attrs: make(map[string]interface{}),
+			Start: time.Now(),
+		},
+	}
+	t.mu.Unlock()
+	if parent, ok := ctx.Value(recordSpanCtxKey{}).(*recordSpan); ok {
+		span.span.ParentID = parent.span.ID
+	}
+	return context.WithValue(ctx, recordSpanCtxKey{}, span), span
+}
+
+// Spans ended spans in end order
+func (t *RecordTracer) Spans() []RecordSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	spans := make([]RecordSpan, len(t.spans))
+	copy(spans, t.spans)
+	return spans
+}
+
+// Reset clear recorded spans
+func (t *RecordTracer) Reset() {
+	t.mu.Lock()
+	t.spans = nil
+	t.mu.Unlock()
+}
+
+type recordSpan struct {
+	tracer *RecordTracer
+	mu     sync.Mutex
+	span   RecordSpan
+}
+
+// SetAttr set attr
+func (s *recordSpan) SetAttr(strKey string, valIf interface{}) {
+	s.mu.Lock()
+	s.span.Attrs[strKey] = valIf
+	s.mu.Unlock()
+}
+
+// End end span
+func (s *recordSpan) End(err error) {
+	s.mu.Lock()
+	s.span.Err = err
+	s.span.End = time.Now()
+	span := s.span
+	// recorded copy must not share attrs with live span, SetAttr may follow End
+	span.Attrs = make(map[string]interface{}, len(s.span.Attrs))
+	for k, v := range s.span.Attrs {
+		span.Attrs[k] = v
+	}
+	s.mu.Unlock()
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, span)
+	s.tracer.mu.Unlock()
+}
@@ -11,6 +11,12 @@ var (
 	ErrGetterIf = fmt.Errorf("err GetterIf")
 	// ErrRateLimit rate limit err
 	ErrRateLimit = fmt.Errorf("err ratelimit")
+	// ErrNotSupport CacheIf not support err
+	ErrNotSupport = fmt.Errorf("err not support")
+	// ErrNamespace namespace not set err
+	ErrNamespace = fmt.Errorf("err namespace not set")
+	// ErrClosed ICache closed err
+	ErrClosed = fmt.Errorf("err closed")
 )
 
 func cloneBytes(b []byte) []byte {
@@ -1,7 +1,10 @@
 package icache
 
+import "time"
+
 // View view
 type View struct {
-	v   interface{}
-	ttl int32
+	v    interface{}
+	ttl  time.Duration
+	idle time.Duration
 }
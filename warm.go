@@ -0,0 +1,234 @@
+package icache
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxWarmErrs = 100
+
+	// back off of rate limited keys, fixed if ErrRateLimit comes from GetterIf or interceptor
+	defaultWarmBackoff = 100 * time.Millisecond
+	minWarmBackoff     = time.Millisecond
+	maxWarmBackoff     = time.Second
+)
+
+// KeyIter key iterator, Next return io.EOF when done
+type KeyIter interface {
+	Next() (string, error)
+}
+
+// KeyIterFunc func
+type KeyIterFunc func() (string, error)
+
+// Next next key
+func (f KeyIterFunc) Next() (string, error) {
+	return f()
+}
+
+// SliceKeyIter iterate keys in slice
+func SliceKeyIter(keys []string) KeyIter {
+	idx := 0
+	return KeyIterFunc(func() (string, error) {
+		if idx >= len(keys) {
+			return "", io.EOF
+		}
+		idx++
+		return keys[idx-1], nil
+	})
+}
+
+// ReaderKeyIter iterate keys from reader, one key per line,
+// empty lines and lines start with '#' are skipped
+func ReaderKeyIter(r io.Reader) KeyIter {
+	scanner := bufio.NewScanner(r)
+	return KeyIterFunc(func() (string, error) {
+		for scanner.Scan() {
+			strKey := strings.TrimSpace(scanner.Text())
+			if strKey == "" || strings.HasPrefix(strKey, "#") {
+				continue
+			}
+			return strKey, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	})
+}
+
+// WriteKeys write keys one per line, can be read by ReaderKeyIter,
+// e.g. save hot keys before exit and warm up the next process
+func WriteKeys(w io.Writer, keys []string) error {
+	bw := bufio.NewWriter(w)
+	for _, strKey := range keys {
+		if _, err := bw.WriteString(strKey + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WarmErr warm up fail key
+type WarmErr struct {
+	Key string
+	Err error
+}
+
+// WarmStat warm up stat
+type WarmStat struct {
+	Total  int64     // keys read from iter
+	Loaded int64     // keys loaded into cache
+	Failed int64     // keys failed
+	Errs   []WarmErr // first maxWarmErrs failures
+}
+
+// WarmProgressFunc warm up progress callback, called after each key
+type WarmProgressFunc func(stat WarmStat)
+
+// Warm load keys into cache through getter and flight group with iConcurrency workers,
+// wait and retry when rate limited, stop when ctx done or iter fail
+func (ic *ICache) Warm(ctx context.Context, iter KeyIter, iConcurrency int, progress WarmProgressFunc) (WarmStat, error) {
+	if iConcurrency <= 0 {
+		iConcurrency = 1
+	}
+	var (
+		mu   sync.Mutex
+		stat WarmStat
+		wg   sync.WaitGroup
+	)
+	keyCh := make(chan string)
+	for i := 0; i < iConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for strKey := range keyCh {
+				err := ic.warmKey(ctx, strKey)
+				mu.Lock()
+				if err != nil {
+					stat.Failed++
+					if len(stat.Errs) < maxWarmErrs {
+						stat.Errs = append(stat.Errs, WarmErr{Key: strKey, Err: err})
+					}
+				} else {
+					stat.Loaded++
+				}
+				if progress != nil {
+					progress(stat)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var iterErr error
+loop:
+	for {
+		strKey, err := iter.Next()
+		if err != nil {
+			if err != io.EOF {
+				iterErr = err
+			}
+			break
+		}
+		select {
+		case keyCh <- strKey:
+			mu.Lock()
+			stat.Total++
+			mu.Unlock()
+		case <-ctx.Done():
+			iterErr = ctx.Err()
+			break loop
+		}
+	}
+	close(keyCh)
+	wg.Wait()
+	return stat, iterErr
+}
+
+// warmKey load one key, retry when rate limited
+func (ic *ICache) warmKey(ctx context.Context, strKey string) error {
+	for {
+		err := ic.Get(ctx, strKey, &warmSink{})
+		if err != ErrRateLimit {
+			return err
+		}
+		select {
+		case <-time.After(ic.warmBackoff()):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// warmBackoff wait one token of rate limiter, clamped to [minWarmBackoff, maxWarmBackoff]
+func (ic *ICache) warmBackoff() time.Duration {
+	if ic.rateLimiter == nil || ic.rateLimiter.Rate() <= 0 {
+		return defaultWarmBackoff
+	}
+	wait := time.Duration(float64(time.Second) / ic.rateLimiter.Rate())
+	if wait < minWarmBackoff {
+		return minWarmBackoff
+	}
+	if wait > maxWarmBackoff {
+		return maxWarmBackoff
+	}
+	return wait
+}
+
+// warmSink accept any value, only used to fill cache
+type warmSink struct {
+	view View
+}
+
+// SetView set view
+func (ws *warmSink) SetView(v View) error {
+	ws.view = v
+	return nil
+}
+
+// GetView get view
+func (ws *warmSink) GetView() (View, error) {
+	return ws.view, nil
+}
+
+// SetBytes set bytes
+func (ws *warmSink) SetBytes(b []byte) error {
+	ws.view.v = cloneBytes(b)
+	return nil
+}
+
+// SetString set string
+func (ws *warmSink) SetString(s string) error {
+	ws.view.v = s
+	return nil
+}
+
+// SetObj set obj
+func (ws *warmSink) SetObj(obj interface{}) error {
+	ws.view.v = obj
+	return nil
+}
+
+// SetTTL set ttl in seconds
+func (ws *warmSink) SetTTL(iTTL int32) error {
+	ws.view.ttl = time.Duration(iTTL) * time.Second
+	return nil
+}
+
+// SetTTLDuration set ttl
+func (ws *warmSink) SetTTLDuration(ttl time.Duration) error {
+	ws.view.ttl = ttl
+	return nil
+}
+
+// SetIdleTTL set idle timeout
+func (ws *warmSink) SetIdleTTL(idle time.Duration) error {
+	ws.view.idle = idle
+	return nil
+}